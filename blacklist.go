@@ -0,0 +1,293 @@
+package luxtbot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const blacklistDataName = "blacklist"
+
+// ErrEventBlocked is returned by the blacklist hook for events ignored.
+const ErrEventBlocked Error = "err.event-blocked"
+
+// BlockEntry blocks the events of a user. A zero BotID means all bots,
+// a zero GroupID means everywhere, and a zero Expire means never expire.
+type BlockEntry struct {
+	UserID  int64  `json:"user_id"`
+	BotID   int64  `json:"bot_id,omitempty"`
+	GroupID int64  `json:"group_id,omitempty"`
+	Expire  int64  `json:"expire,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (be *BlockEntry) expired(now int64) bool {
+	return be.Expire != 0 && be.Expire <= now
+}
+
+func (be *BlockEntry) sameScope(o *BlockEntry) bool {
+	return be.UserID == o.UserID && be.BotID == o.BotID && be.GroupID == o.GroupID
+}
+
+var (
+	blacklist     []BlockEntry
+	blacklistLock sync.RWMutex
+)
+
+func loadBlacklist() error {
+	var list []BlockEntry
+	if err := LoadData(blacklistDataName, &list); err != nil {
+		return err
+	}
+	blacklistLock.Lock()
+	blacklist = list
+	blacklistLock.Unlock()
+	return nil
+}
+
+// activeBlacklist returns a copy of the entries not expired,
+// blacklistLock must be held.
+func activeBlacklist() []BlockEntry {
+	now := time.Now().Unix()
+	list := make([]BlockEntry, 0, len(blacklist))
+	for _, be := range blacklist {
+		if !be.expired(now) {
+			list = append(list, be)
+		}
+	}
+	return list
+}
+
+// saveBlacklist saves the list, and swaps it in only if it is saved,
+// blacklistLock must be held.
+func saveBlacklist(next []BlockEntry) error {
+	if err := SaveData(blacklistDataName, next); err != nil {
+		return err
+	}
+	blacklist = next
+	return nil
+}
+
+// Block adds the entry to the blacklist and saves it,
+// an entry of the same scope is replaced. Super admins are never blocked.
+func Block(entry BlockEntry) error {
+	if entry.UserID == 0 {
+		return errors.New(T("block.no-user"))
+	}
+	if containsID(CurConf().SAdmins, entry.UserID) {
+		return errors.New(T("block.sadmin", entry.UserID))
+	}
+	blacklistLock.Lock()
+	defer blacklistLock.Unlock()
+	next := activeBlacklist()
+	for i := range next {
+		if next[i].sameScope(&entry) {
+			next[i] = entry
+			return saveBlacklist(next)
+		}
+	}
+	return saveBlacklist(append(next, entry))
+}
+
+// Unblock removes the entry of the scope from the blacklist and saves it.
+func Unblock(userID, botID, groupID int64) error {
+	target := BlockEntry{UserID: userID, BotID: botID, GroupID: groupID}
+	blacklistLock.Lock()
+	defer blacklistLock.Unlock()
+	next := activeBlacklist()
+	for i := range next {
+		if next[i].sameScope(&target) {
+			return saveBlacklist(append(next[:i], next[i+1:]...))
+		}
+	}
+	return errors.New(T("block.not-found", userID))
+}
+
+// GetBlacklist returns the entries not expired.
+func GetBlacklist() []BlockEntry {
+	blacklistLock.RLock()
+	defer blacklistLock.RUnlock()
+	return activeBlacklist()
+}
+
+// IsBlocked reports whether the events of the user to the bot in the group are blocked.
+func IsBlocked(userID, botID, groupID int64) bool {
+	now := time.Now().Unix()
+	blacklistLock.RLock()
+	defer blacklistLock.RUnlock()
+	for _, be := range blacklist {
+		if be.UserID == userID && (be.BotID == 0 || be.BotID == botID) &&
+			(be.GroupID == 0 || be.GroupID == groupID) && !be.expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBotID reports whether the user is one of the bots configured.
+func isBotID(userID int64) bool {
+	botsLock.RLock()
+	defer botsLock.RUnlock()
+	for _, bInfo := range Conf.BotInfos {
+		if bInfo.BotID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// blacklistHook is the first EventInHook, it drops the events of blocked
+// users, and the messages from the bots configured to avoid reply loops.
+// Super admins are never blocked, even by entries made before they are.
+func blacklistHook(e *Event, bInfo BotInfo) error {
+	if e.PostType == MetaEvent || e.UserID == 0 || containsID(CurConf().SAdmins, e.UserID) {
+		return nil
+	}
+	if e.PostType == MessageEvent && isBotID(e.UserID) || IsBlocked(e.UserID, bInfo.BotID, e.GroupID) {
+		return fmt.Errorf("%w: %d", ErrEventBlocked, e.UserID)
+	}
+	return nil
+}
+
+// parseExpire parses durations like 30m, 2h or 7d, 0 means never.
+func parseExpire(s string) (int64, error) {
+	if s == "0" {
+		return 0, nil
+	}
+	var (
+		d   time.Duration
+		err error
+	)
+	if strings.HasSuffix(s, "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(s, "d"))
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, errors.New(T("block.bad-duration", s))
+	}
+	return time.Now().Add(d).Unix(), nil
+}
+
+func InitDefaultBlacklistManager(plgId int) {
+	plg := NewPlugin(plgId).SetName("Luxtbot黑名单管理").setI18n("block.name", "block.info").SetAdminPlugin()
+	rule := NewRule().AddMustRules(IsAdmin)
+	addBlockUnit(plg, rule)
+	addUnblockUnit(plg, rule)
+	addBlacklistUnit(plg, rule)
+}
+
+// parseBlockScope parses the user and the scope: all, bot, group or a group id,
+// the current group if in a group, or else all by default.
+// Only super admins could use the scope all.
+func parseBlockScope(params []string, e *Event, bInfo BotInfo) (BlockEntry, []string, error) {
+	entry, rest, err := parseBlockParams(params, e, bInfo)
+	if err == nil && entry.BotID == 0 && entry.GroupID == 0 && !IsSAdmin(e, bInfo) {
+		return entry, nil, errors.New(T("block.all-sadmin"))
+	}
+	return entry, rest, err
+}
+
+func parseBlockParams(params []string, e *Event, bInfo BotInfo) (BlockEntry, []string, error) {
+	var entry BlockEntry
+	if len(params) < 1 {
+		return entry, nil, errors.New(T("block.usage"))
+	}
+	uid, err := strconv.ParseInt(params[0], 10, 64)
+	if err != nil {
+		return entry, nil, errors.New(T("block.bad-id", params[0]))
+	}
+	entry.UserID = uid
+	entry.GroupID = e.GroupID
+	rest := params[1:]
+	if len(rest) == 0 {
+		return entry, rest, nil
+	}
+	switch rest[0] {
+	case "all":
+		entry.GroupID = 0
+	case "bot":
+		entry.GroupID, entry.BotID = 0, bInfo.BotID
+	case "group":
+		if e.GroupID == 0 {
+			return entry, nil, errors.New(T("block.usage"))
+		}
+	default:
+		gid, err := strconv.ParseInt(rest[0], 10, 64)
+		if err != nil || gid <= 0 {
+			// not a scope, the default one is used, and 0 is the duration
+			return entry, rest, nil
+		}
+		entry.GroupID = gid
+	}
+	return entry, rest[1:], nil
+}
+
+// block <qq> [all|bot|group|<group id>] [duration] [reason]
+func addBlockUnit(plg *Plugin, rule *Rule) {
+	plg.AddCommandUnit().SetCommand("block").AddAliases("拉黑").SetRule(rule).SetProcessor(func(e *Event, params []string, bInfo BotInfo) {
+		msg := MakeArrayMsg(1)
+		entry, rest, err := parseBlockScope(params, e, bInfo)
+		if err == nil && len(rest) > 0 {
+			entry.Expire, err = parseExpire(rest[0])
+			rest = rest[1:]
+		}
+		if err == nil {
+			entry.Reason = strings.Join(rest, " ")
+			err = Block(entry)
+		}
+		if err != nil {
+			msg.AddText(err.Error())
+		} else {
+			msg.AddText(T("block.blocked", entry.UserID))
+		}
+		sendMsg(msg, e, bInfo)
+	}).AddToCmdChain()
+}
+
+// unblock <qq> [all|bot|group|<group id>]
+func addUnblockUnit(plg *Plugin, rule *Rule) {
+	plg.AddCommandUnit().SetCommand("unblock").AddAliases("解除拉黑").SetRule(rule).SetProcessor(func(e *Event, params []string, bInfo BotInfo) {
+		msg := MakeArrayMsg(1)
+		entry, _, err := parseBlockScope(params, e, bInfo)
+		if err == nil {
+			err = Unblock(entry.UserID, entry.BotID, entry.GroupID)
+		}
+		if err != nil {
+			msg.AddText(err.Error())
+		} else {
+			msg.AddText(T("block.unblocked", entry.UserID))
+		}
+		sendMsg(msg, e, bInfo)
+	}).AddToCmdChain()
+}
+
+func addBlacklistUnit(plg *Plugin, rule *Rule) {
+	plg.AddCommandUnit().SetCommand("blacklist").AddAliases("黑名单").SetRule(rule).SetProcessor(func(e *Event, params []string, bInfo BotInfo) {
+		list := GetBlacklist()
+		msg := MakeArrayMsg(len(list) + 1)
+		if len(list) == 0 {
+			msg.AddText(T("block.empty"))
+		}
+		for _, be := range list {
+			scope := T("block.scope-all")
+			switch {
+			case be.GroupID != 0:
+				scope = T("block.scope-group", be.GroupID)
+			case be.BotID != 0:
+				scope = T("block.scope-bot", be.BotID)
+			}
+			expire := T("block.never")
+			if be.Expire != 0 {
+				expire = time.Unix(be.Expire, 0).Format("2006-01-02 15:04")
+			}
+			msg.AddText(fmt.Sprintf("%d - %v - %v %v\n", be.UserID, scope, expire, be.Reason))
+		}
+		sendMsg(msg, e, bInfo)
+	}).AddToCmdChain()
+}
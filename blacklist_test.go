@@ -0,0 +1,125 @@
+package luxtbot
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useDataDir makes dir the data-dir until the test ends.
+func useDataDir(t *testing.T, dir string) {
+	old := Conf.DataDir
+	Conf.DataDir = dir
+	t.Cleanup(func() { Conf.DataDir = old })
+}
+
+// useBlacklist starts the test with the entries and restores the blacklist after.
+func useBlacklist(t *testing.T, list []BlockEntry) {
+	blacklistLock.Lock()
+	old := blacklist
+	blacklist = list
+	blacklistLock.Unlock()
+	t.Cleanup(func() {
+		blacklistLock.Lock()
+		blacklist = old
+		blacklistLock.Unlock()
+	})
+}
+
+func TestIsBlocked(t *testing.T) {
+	past := time.Now().Add(-time.Hour).Unix()
+	useBlacklist(t, []BlockEntry{
+		{UserID: 1},
+		{UserID: 2, BotID: 10},
+		{UserID: 3, GroupID: 100},
+		{UserID: 4, Expire: past},
+	})
+	tests := []struct {
+		userID, botID, groupID int64
+		want                   bool
+	}{
+		{1, 10, 100, true},
+		{1, 11, 0, true},
+		{2, 10, 100, true},
+		{2, 11, 100, false},
+		{3, 10, 100, true},
+		{3, 10, 101, false},
+		{3, 10, 0, false},
+		{4, 10, 100, false},
+		{5, 10, 100, false},
+	}
+	for _, tt := range tests {
+		if got := IsBlocked(tt.userID, tt.botID, tt.groupID); got != tt.want {
+			t.Errorf("IsBlocked(%d, %d, %d) = %v, want %v", tt.userID, tt.botID, tt.groupID, got, tt.want)
+		}
+	}
+}
+
+func TestBlockPersist(t *testing.T) {
+	useDataDir(t, t.TempDir())
+	useBlacklist(t, []BlockEntry{{UserID: 9, Expire: time.Now().Add(-time.Hour).Unix()}})
+	steps := []struct {
+		name string
+		do   func() error
+		want []BlockEntry
+	}{
+		{"block", func() error { return Block(BlockEntry{UserID: 1, Reason: "a"}) }, []BlockEntry{{UserID: 1, Reason: "a"}}},
+		{"replace same scope", func() error { return Block(BlockEntry{UserID: 1, Reason: "b"}) }, []BlockEntry{{UserID: 1, Reason: "b"}}},
+		{"another scope", func() error { return Block(BlockEntry{UserID: 1, GroupID: 100}) }, []BlockEntry{{UserID: 1, Reason: "b"}, {UserID: 1, GroupID: 100}}},
+		{"unblock", func() error { return Unblock(1, 0, 0) }, []BlockEntry{{UserID: 1, GroupID: 100}}},
+	}
+	for _, step := range steps {
+		if err := step.do(); err != nil {
+			t.Fatalf("%v: %v", step.name, err)
+		}
+		if got := GetBlacklist(); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%v: GetBlacklist() = %v, want %v", step.name, got, step.want)
+		}
+		var saved []BlockEntry
+		if err := LoadData(blacklistDataName, &saved); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(saved, step.want) {
+			t.Errorf("%v: saved %v, want %v", step.name, saved, step.want)
+		}
+	}
+	if err := Unblock(2, 0, 0); err == nil {
+		t.Error("Unblock() of a user not blocked succeeded")
+	}
+}
+
+// the blacklist is left as it is if it could not be saved
+func TestBlockSaveFail(t *testing.T) {
+	// a file where the data-dir should be
+	dir := filepath.Join(t.TempDir(), "data")
+	if err := ioutil.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	useDataDir(t, dir)
+	want := []BlockEntry{{UserID: 1}}
+	useBlacklist(t, append([]BlockEntry(nil), want...))
+	if err := Block(BlockEntry{UserID: 2}); err == nil {
+		t.Error("Block() succeeded without saving")
+	}
+	if err := Unblock(1, 0, 0); err == nil {
+		t.Error("Unblock() succeeded without saving")
+	}
+	if got := GetBlacklist(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetBlacklist() = %v, want %v", got, want)
+	}
+}
+
+func TestBlockSAdmin(t *testing.T) {
+	conf := *CurConf()
+	conf.SAdmins = []int64{1}
+	useConf(t, conf)
+	useBlacklist(t, nil)
+	if err := Block(BlockEntry{UserID: 1}); err == nil {
+		t.Error("Block() of a super admin succeeded")
+	}
+	if err := Block(BlockEntry{}); err == nil {
+		t.Error("Block() without a user succeeded")
+	}
+}
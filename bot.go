@@ -2,27 +2,67 @@ package luxtbot
 
 import (
 	"errors"
-	"io/ioutil"
-	"os"
 	"sync"
+	"sync/atomic"
 
 	ws "github.com/gorilla/websocket"
-	yaml "gopkg.in/yaml.v2"
 )
 
 var (
-	Conf Config
-	bots BotCtxs
+	// Conf is the config passed to Init, the reloads are not applied to it,
+	// see CurConf. The BotInfos is kept by AddBot and RemoveBot, guarded by botsLock.
+	Conf     Config
+	confPath string
+	// *Config, swapped by the reloads
+	curConf  atomic.Value
+	bots     BotCtxs
+	botsLock sync.RWMutex
+
+	isStarted bool
 )
 
 type Config struct {
-	BotInfos         []BotInfo `yaml:"bots"`
-	LogConf          LogConf   `yaml:"log"`
-	SAdmins          []int64   `yaml:"s-admin"`
-	CallbackPoolSize int       `yaml:"callback-pool-size"`
+	BotInfos         []BotInfo  `yaml:"bots"`
+	LogConf          LogConf    `yaml:"log"`
+	SAdmins          []int64    `yaml:"s-admin"`
+	CallbackPoolSize int        `yaml:"callback-pool-size"`
+	HotReload        bool       `yaml:"hot-reload"`
+	ReloadInterval   int        `yaml:"hot-reload-interval"`
+	Render           RenderConf `yaml:"render,omitempty"`
+	Locale           string     `yaml:"locale"`
+
+	// templates.<plugin name>.<key>, overrides the templates of plugins
+	Templates map[string]map[string]string `yaml:"templates,omitempty"`
+	// "<plugin name>" or "<plugin name>.<unit name>" -> rule, see ParseRuleExpr.
+	// A key of a unit is one flat key, not nested ones.
+	Rules map[string]string  `yaml:"rules,omitempty"`
+	Lists map[string][]int64 `yaml:"lists,omitempty"`
+	// custom roles or overrides of the builtin ones, role name -> permission patterns
+	Roles   map[string][]string `yaml:"roles,omitempty"`
+	DataDir string              `yaml:"data-dir"`
+	// units running at the same time, 0 means no limit
+	MaxWorkers int `yaml:"max-workers"`
+	// seconds, 0 means no timeout
+	UnitTimeout int `yaml:"unit-timeout"`
+	// the filter of the messages sent
+	Filter FilterConf `yaml:"filter,omitempty"`
+	Dedup  DedupConf  `yaml:"dedup,omitempty"`
+	// seconds the info of friends, groups and members is cached, 3600 by default
+	InfoCacheTTL int `yaml:"info-cache-ttl"`
+	// the recent messages of every chat
+	History HistoryConf `yaml:"history,omitempty"`
+	// seconds a bot failing to send is not chosen by SendGroupMsg, 600 by default
+	FailoverCooldown int `yaml:"failover-cooldown"`
+
+	// plugins.<name>, decoded by the sections registered with RegisterConfSection
+	Plugins map[string]interface{} `yaml:"plugins,omitempty"`
+
+	sections map[string]interface{}
+	rules    map[string]Judge
+	filter   *outFilter
 }
 
-type BotCtxs = []BotContext
+type BotCtxs = []*BotContext
 
 type BotInfo struct {
 	BotID       int64   `yaml:"id"`
@@ -33,6 +73,12 @@ type BotInfo struct {
 	Timeout     int     `yaml:"time-out"`
 	Admins      []int64 `yaml:"admins"`
 	MessageType string  `yaml:"message-type"`
+	// 0 means off
+	MaxMsgLen    int `yaml:"max-msg-len,omitempty"`
+	ForwardLines int `yaml:"forward-lines,omitempty"`
+
+	// the access-token before ${ENV} expansion, used when saving the config
+	tokenTmpl string
 }
 
 type BotContext struct {
@@ -41,43 +87,101 @@ type BotContext struct {
 	OutChan   chan ApiPost
 	FlagChan  chan byte
 	CloseChan chan byte
+	StopChan  chan byte
 	IsReady   bool
-	BotInfo   *BotInfo
+	IsRunning bool
+	// BotInfo is the info the bot is added with, use Info for the one
+	// updated by the reloads.
+	BotInfo *BotInfo
+
+	// *BotInfo, swapped by the reloads
+	info  atomic.Value
+	cache *infoCache
+	// the parts of the api not written when the connection is broken, guarded by CloseLock
+	unsent []ApiPost
 }
 
+// Init loads the config file and initializes luxtbot, it panics on error.
 func Init(conf string) {
-	var (
-		file *os.File
-		err  error
-		data []byte
-	)
-	file, err = os.Open(conf)
+	if err := InitE(conf); err != nil {
+		panic(err)
+	}
+}
+
+// InitE works like Init, but returns the error instead of panicking.
+func InitE(conf string) error {
+	var err error
+	Conf, err = loadConf(conf)
 	if err != nil {
-		panic("打开配置文件失败：" + conf)
+		return err
 	}
-	data, err = ioutil.ReadAll(file)
-	err = yaml.Unmarshal(data, &Conf)
+	confPath = conf
+	return initAll()
+}
+
+// InitWithConfig initializes luxtbot with a config built by code
+// instead of a file, the config could not be reloaded or saved then.
+func InitWithConfig(conf Config) error {
+	err := checkConf(&conf)
 	if err != nil {
-		panic("打开配置文件失败：" + conf)
+		return errors.New(T("conf.invalid", err))
 	}
+	Conf = conf
+	confPath = ""
+	return initAll()
+}
+
+func initAll() error {
+	setCurConf(Conf)
 	InitLogConf()
+	if err := loadPerms(); err != nil {
+		return errors.New(T("perm.load", err))
+	}
+	if err := loadBlacklist(); err != nil {
+		return errors.New(T("block.load", err))
+	}
+	if err := loadHistory(); err != nil {
+		return errors.New(T("history.load", err))
+	}
+	applyConfSections(Conf.sections)
+	initWorkers()
+	initDedup()
 	InitPluginList()
 	InitBotCtxs()
+	return nil
 }
 
 func Start() {
+	isStarted = true
 	RunBots()
 	RunEventDispatcher()
 	RunRespDispatcher(Conf.CallbackPoolSize)
 	RunBackenPlugin()
+	RunConfWatcher()
+	runHistoryFlusher()
 	select {}
 }
 
+// CurConf returns the running config, which is Conf with the reloads
+// applied. It is shared and must not be modified. Use GetBotInfos for the bots.
+func CurConf() *Config {
+	if conf, ok := curConf.Load().(*Config); ok {
+		return conf
+	}
+	return &Conf
+}
+
+func setCurConf(conf Config) {
+	curConf.Store(&conf)
+}
+
 func getBotCtxByID(botID int64) (*BotContext, error) {
+	botsLock.RLock()
+	defer botsLock.RUnlock()
 	for _, bCtx := range bots {
-		if bCtx.BotInfo.BotID == botID {
-			return &bCtx, nil
+		if bCtx.Info().BotID == botID {
+			return bCtx, nil
 		}
 	}
-	return nil, errors.New("无法找到该ID的Bot实例。")
+	return nil, ErrBotNotFound
 }
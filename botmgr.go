@@ -0,0 +1,305 @@
+package luxtbot
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// AddBot registers a new bot instance while the process is running,
+// the bot will not connect to the CQ server until StartBot is called.
+// If persist is true, the config file will be rewritten.
+func AddBot(bInfo BotInfo, persist bool) error {
+	if bInfo.MessageType == "" {
+		bInfo.MessageType = MsgTypeArray
+	}
+	setBotInfoDefaults(&bInfo)
+	if err := validateBotInfo(&bInfo); err != nil {
+		return errors.New(T("err.bot-info", err))
+	}
+	botsLock.Lock()
+	for _, bCtx := range bots {
+		if bCtx.Info().BotID == bInfo.BotID {
+			botsLock.Unlock()
+			return ErrBotExists
+		}
+	}
+	bots = append(bots, newBotCtx(&bInfo))
+	Conf.BotInfos = append(Conf.BotInfos, bInfo)
+	botsLock.Unlock()
+	LBLogger.WithField("BotName", bInfo.Name).WithField("BotID", bInfo.BotID).Infoln(T("bot.added"))
+	if persist {
+		return SaveConf()
+	}
+	return nil
+}
+
+// StartBot connects a registered bot to its CQ server and starts the heart check.
+func StartBot(botID int64) error {
+	bCtx, err := getBotCtxByID(botID)
+	if err != nil {
+		return err
+	}
+	runBot(bCtx)
+	return nil
+}
+
+// StopBot closes the connection of a bot, the bot is still registered
+// and could be started again by StartBot.
+func StopBot(botID int64) error {
+	bCtx, err := getBotCtxByID(botID)
+	if err != nil {
+		return err
+	}
+	stopBot(bCtx)
+	LBLogger.WithField("BotName", bCtx.Info().Name).Infoln(T("bot.stopped"))
+	return nil
+}
+
+// RemoveBot stops a bot and removes it from the registry.
+// If persist is true, the config file will be rewritten.
+func RemoveBot(botID int64, persist bool) error {
+	var target *BotContext
+	botsLock.Lock()
+	for i, bCtx := range bots {
+		if bCtx.Info().BotID == botID {
+			target = bCtx
+			bots = append(bots[:i], bots[i+1:]...)
+			break
+		}
+	}
+	for i, bInfo := range Conf.BotInfos {
+		if bInfo.BotID == botID {
+			Conf.BotInfos = append(Conf.BotInfos[:i], Conf.BotInfos[i+1:]...)
+			break
+		}
+	}
+	botsLock.Unlock()
+	if target == nil {
+		return ErrBotNotFound
+	}
+	stopBot(target)
+	LBLogger.WithField("BotName", target.Info().Name).WithField("BotID", botID).Infoln(T("bot.removed"))
+	if persist {
+		return SaveConf()
+	}
+	return nil
+}
+
+// GetBotInfos returns the infos of all registered bots.
+func GetBotInfos() []BotInfo {
+	botsLock.RLock()
+	defer botsLock.RUnlock()
+	infos := make([]BotInfo, 0, len(bots))
+	for _, bCtx := range bots {
+		infos = append(infos, *bCtx.Info())
+	}
+	return infos
+}
+
+// SaveConf writes the bots back to the config file passed to Init.
+// Only the bots are replaced, the rest of the file is kept as it is,
+// so neither the defaults nor a config older than the file is written.
+// The bots already in the file are kept as written too.
+func SaveConf() error {
+	if confPath == "" {
+		return errors.New(T("err.no-conf-path"))
+	}
+	format, err := confFormat(confPath)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		return err
+	}
+	raw, err := toYAML(data, format)
+	if err != nil {
+		return err
+	}
+	var doc yaml.MapSlice
+	if err = yaml.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	rawInfos := rawBotInfos(doc)
+	botsLock.RLock()
+	bInfos := make([]interface{}, 0, len(Conf.BotInfos))
+	for _, bInfo := range Conf.BotInfos {
+		if rawInfo, ok := rawInfos[bInfo.BotID]; ok {
+			bInfos = append(bInfos, rawInfo)
+			continue
+		}
+		if bInfo.tokenTmpl != "" {
+			bInfo.Token = bInfo.tokenTmpl
+		}
+		bInfos = append(bInfos, bInfo)
+	}
+	botsLock.RUnlock()
+	doc = setMapItem(doc, "bots", bInfos)
+	data, err = encodeConf(doc, format)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(confPath, data, 0644)
+}
+
+// rawBotInfos returns the bots written in the config file by id.
+func rawBotInfos(doc yaml.MapSlice) map[int64]interface{} {
+	infos := make(map[int64]interface{})
+	for _, item := range doc {
+		if item.Key != "bots" {
+			continue
+		}
+		list, _ := item.Value.([]interface{})
+		for _, rawInfo := range list {
+			fields, _ := rawInfo.(yaml.MapSlice)
+			for _, field := range fields {
+				if field.Key != "id" {
+					continue
+				}
+				if id, err := strconv.ParseInt(fmt.Sprint(field.Value), 10, 64); err == nil {
+					infos[id] = rawInfo
+				}
+			}
+		}
+	}
+	return infos
+}
+
+// setMapItem sets the value of the key, which is appended if not found.
+func setMapItem(doc yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i := range doc {
+		if doc[i].Key == key {
+			doc[i].Value = value
+			return doc
+		}
+	}
+	return append(doc, yaml.MapItem{Key: key, Value: value})
+}
+
+func InitDefaultBotManager(plgId int) {
+	plg := NewPlugin(plgId).SetName("Luxtbot实例管理").setI18n("botmgr.name", "botmgr.info").SetAdminPlugin()
+	rule := NewRule().AddMustRules(IsSAdmin)
+	addBotListUnit(plg, rule)
+	addBotAddUnit(plg, rule)
+	addBotSwitchUnit(plg, rule)
+}
+
+func addBotListUnit(plg *Plugin, rule *Rule) {
+	plg.AddCommandUnit().SetCommand("bots").AddAliases("bot列表").SetRule(rule).SetProcessor(func(e *Event, params []string, bInfo BotInfo) {
+		botsLock.RLock()
+		msg := MakeArrayMsg(len(bots))
+		for _, bCtx := range bots {
+			state := "OFF"
+			if bCtx.IsReady {
+				state = "ON"
+			} else if bCtx.IsRunning {
+				state = "CONNECTING"
+			}
+			msg.AddText(fmt.Sprintf("%d. %v: %v:%d - %v \n", bCtx.Info().BotID, bCtx.Info().Name, bCtx.Info().Host, bCtx.Info().Port, state))
+		}
+		botsLock.RUnlock()
+		sendMsg(msg, e, bInfo)
+	}).AddToCmdChain()
+}
+
+// botadd <id> <host> <port> [token] [name]
+// only in private messages, so that the token is not left in group chats.
+func addBotAddUnit(plg *Plugin, rule *Rule) {
+	plg.AddCommandUnit().SetCommand("botadd").AddAliases("添加bot").SetRule(rule).SetProcessor(func(e *Event, params []string, bInfo BotInfo) {
+		msg := MakeArrayMsg(1)
+		if e.MessageType != MsgTypePrivate {
+			msg.AddText(T("botmgr.private-only"))
+			sendMsg(msg, e, bInfo)
+			return
+		}
+		newInfo, err := parseBotInfo(params)
+		if err == nil {
+			err = AddBot(newInfo, true)
+		}
+		if err == nil {
+			err = StartBot(newInfo.BotID)
+		}
+		if err != nil {
+			msg.AddText(err.Error())
+		} else {
+			msg.AddText(T("botmgr.added", newInfo.BotID, newInfo.Name))
+		}
+		sendMsg(msg, e, bInfo)
+	}).AddToCmdChain()
+}
+
+func addBotSwitchUnit(plg *Plugin, rule *Rule) {
+	type botOp struct {
+		cmd     string
+		aliases []string
+		do      func(botID int64) error
+		done    string
+		notSelf bool
+	}
+	ops := []botOp{
+		{"boton", []string{"启动bot"}, StartBot, "botmgr.started", false},
+		{"botoff", []string{"停止bot"}, StopBot, "botmgr.stopped", true},
+		{"botrm", []string{"移除bot"}, func(botID int64) error { return RemoveBot(botID, true) }, "botmgr.removed", true},
+	}
+	for _, op := range ops {
+		op := op
+		plg.AddCommandUnit().SetCommand(op.cmd).AddAliases(op.aliases...).SetRule(rule).SetProcessor(func(e *Event, params []string, bInfo BotInfo) {
+			msg := MakeArrayMsg(1)
+			botID, err := parseBotID(params)
+			if err == nil && op.notSelf && botID == bInfo.BotID {
+				err = errors.New(T("botmgr.self"))
+			}
+			if err == nil {
+				err = op.do(botID)
+			}
+			if err != nil {
+				msg.AddText(err.Error())
+			} else {
+				msg.AddText(T(op.done, botID))
+			}
+			sendMsg(msg, e, bInfo)
+		}).AddToCmdChain()
+	}
+}
+
+func parseBotID(params []string) (int64, error) {
+	if len(params) < 1 {
+		return 0, errors.New(T("botmgr.no-id"))
+	}
+	botID, err := strconv.ParseInt(params[0], 10, 64)
+	if err != nil {
+		return 0, errors.New(T("botmgr.bad-id", params[0]))
+	}
+	return botID, nil
+}
+
+func parseBotInfo(params []string) (BotInfo, error) {
+	var bInfo BotInfo
+	if len(params) < 3 {
+		return bInfo, errors.New(T("botmgr.botadd-params"))
+	}
+	botID, err := parseBotID(params)
+	if err != nil {
+		return bInfo, err
+	}
+	port, err := strconv.Atoi(params[2])
+	if err != nil {
+		return bInfo, errors.New(T("botmgr.bad-port", params[2]))
+	}
+	bInfo.BotID = botID
+	bInfo.Host = params[1]
+	bInfo.Port = port
+	bInfo.Timeout = DefaultTimeout
+	bInfo.MessageType = MsgTypeArray
+	if len(params) > 3 {
+		bInfo.Token = params[3]
+	}
+	if len(params) > 4 {
+		bInfo.Name = params[4]
+	}
+	return bInfo, nil
+}
@@ -0,0 +1,68 @@
+package luxtbot
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveConf(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "yaml",
+			file:    "config.yml",
+			content: "s-admin: [1]\nbots:\n  - id: 10\n    host: 127.0.0.1\n    port: 6700\n    access-token: ${TOKEN}\n  - id: 11\n    host: 127.0.0.1\n    port: 6701\n",
+			want:    []string{"s-admin:\n- 1", "access-token: ${TOKEN}", "id: 12", "port: 6702"},
+			notWant: []string{"id: 11", "secret", "log:", "data-dir"},
+		},
+		{
+			name:    "json",
+			file:    "config.json",
+			content: `{"s-admin": [1], "bots": [{"id": 10, "host": "127.0.0.1", "port": 6700}, {"id": 11, "host": "127.0.0.1", "port": 6701}]}`,
+			want:    []string{`"id": 10`, `"id": 12`},
+			notWant: []string{`"id": 11`, `"log"`},
+		},
+	}
+	oldPath, oldInfos := confPath, Conf.BotInfos
+	defer func() {
+		confPath, Conf.BotInfos = oldPath, oldInfos
+	}()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			confPath = filepath.Join(t.TempDir(), tt.file)
+			if err := ioutil.WriteFile(confPath, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			// 11 is removed and 12 is added
+			Conf.BotInfos = []BotInfo{
+				{BotID: 10, Host: "127.0.0.1", Port: 6700, Name: "10", Token: "secret"},
+				{BotID: 12, Host: "127.0.0.1", Port: 6702, Name: "12"},
+			}
+			if err := SaveConf(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadFile(confPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			saved := string(data)
+			for _, s := range tt.want {
+				if !strings.Contains(saved, s) {
+					t.Errorf("saved config has no %q:\n%s", s, saved)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(saved, s) {
+					t.Errorf("saved config has %q:\n%s", s, saved)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package luxtbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	EnvPrefix = "LUXTBOT_"
+
+	ConfFormatYAML = "yaml"
+	ConfFormatJSON = "json"
+	ConfFormatTOML = "toml"
+
+	DefaultCallbackPoolSize = 1000
+	DefaultLogLevel         = "WARN"
+)
+
+var envRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// loadConf reads the config file, expands ${ENV}, applies the LUXTBOT_*
+// environment overrides and the defaults, and then validates it.
+// The format is detected by the extension of the file: .yml/.yaml, .json or .toml.
+func loadConf(path string) (Config, error) {
+	var conf Config
+	format, err := confFormat(path)
+	if err != nil {
+		return conf, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return conf, errors.New(T("conf.open", err))
+	}
+	expanded, err := expandEnv(data, format)
+	if err != nil {
+		return conf, errors.New(T("conf.file", path, err))
+	}
+	expanded, err = toYAML(expanded, format)
+	if err == nil {
+		err = yaml.UnmarshalStrict(expanded, &conf)
+	}
+	if err != nil {
+		return conf, errors.New(T("conf.parse", path, err))
+	}
+	if raw, err := toYAML(data, format); err == nil {
+		keepTokenTmpls(raw, &conf)
+	}
+	err = overrideByEnv(&conf)
+	if err != nil {
+		return conf, errors.New(T("conf.env-override", err))
+	}
+	err = checkConf(&conf)
+	if err != nil {
+		return conf, errors.New(T("conf.file-invalid", path, err))
+	}
+	return conf, nil
+}
+
+// checkConf applies the defaults, then validates the config,
+// the rules and the plugin config sections in it.
+func checkConf(conf *Config) error {
+	setConfDefaults(conf)
+	err := validateConf(conf)
+	if err != nil {
+		return err
+	}
+	conf.rules, err = parseConfRules(conf)
+	if err != nil {
+		return err
+	}
+	conf.filter, err = newOutFilter(conf.Filter)
+	if err != nil {
+		return err
+	}
+	conf.sections, err = decodeConfSections(conf.Plugins)
+	return err
+}
+
+func confFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return ConfFormatYAML, nil
+	case ".json":
+		return ConfFormatJSON, nil
+	case ".toml":
+		return ConfFormatTOML, nil
+	}
+	return "", errors.New(T("conf.format", path))
+}
+
+// toYAML converts a JSON or TOML document to YAML,
+// so that all of the formats share the yaml tags of Config.
+func toYAML(data []byte, format string) ([]byte, error) {
+	var v interface{}
+	switch format {
+	case ConfFormatJSON:
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			return nil, err
+		}
+		v = jsonNumbers(v)
+	case ConfFormatTOML:
+		m := make(map[string]interface{})
+		if err := toml.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		v = m
+	default:
+		return data, nil
+	}
+	return yaml.Marshal(v)
+}
+
+// jsonNumbers turns json.Number into int64 or float64,
+// otherwise they would be marshaled to YAML as strings.
+func jsonNumbers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = jsonNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = jsonNumbers(item)
+		}
+	}
+	return v
+}
+
+// encodeConf marshals the config, or a YAML document of it, in the given format.
+func encodeConf(conf interface{}, format string) ([]byte, error) {
+	data, err := yaml.Marshal(conf)
+	if err != nil || format == ConfFormatYAML {
+		return data, err
+	}
+	var v interface{}
+	err = yaml.Unmarshal(data, &v)
+	if err != nil {
+		return nil, err
+	}
+	v = stringKeys(v)
+	if format == ConfFormatJSON {
+		return json.MarshalIndent(v, "", "  ")
+	}
+	var buf bytes.Buffer
+	err = toml.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+// stringKeys turns the map[interface{}]interface{} decoded by yaml
+// into map[string]interface{} which could be encoded as JSON or TOML.
+func stringKeys(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range val {
+			val[i] = stringKeys(item)
+		}
+	}
+	return v
+}
+
+// expandEnv replaces every ${NAME} with the value of the environment variable,
+// an undefined variable is reported as an error rather than an empty string.
+// The comments of YAML and TOML are left as they are.
+func expandEnv(data []byte, format string) ([]byte, error) {
+	var missing []string
+	expand := func(m []byte) []byte {
+		name := string(envRegex.FindSubmatch(m)[1])
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+			return m
+		}
+		return []byte(val)
+	}
+	var result []byte
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		cut := len(line)
+		if format != ConfFormatJSON {
+			cut = commentStart(line)
+		}
+		result = append(result, envRegex.ReplaceAllFunc(line[:cut], expand)...)
+		result = append(result, line[cut:]...)
+	}
+	if len(missing) != 0 {
+		return nil, errors.New(T("conf.env-undefined", strings.Join(missing, ", ")))
+	}
+	return result, nil
+}
+
+// commentStart returns where the comment of a YAML or TOML line starts,
+// a # at the start of the line or after a space and out of the quotes.
+func commentStart(line []byte) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// a quote in a plain value, like it's, starts nothing
+			if i == 0 || strings.IndexByte(" \t:[{,=", line[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return i
+		}
+	}
+	return len(line)
+}
+
+// keepTokenTmpls records the access-tokens written as ${ENV},
+// so that SaveConf never writes the secrets back to the file.
+func keepTokenTmpls(raw []byte, conf *Config) {
+	var rawConf struct {
+		BotInfos []struct {
+			Token string `yaml:"access-token"`
+		} `yaml:"bots"`
+	}
+	if yaml.Unmarshal(raw, &rawConf) != nil || len(rawConf.BotInfos) != len(conf.BotInfos) {
+		return
+	}
+	for i, rawInfo := range rawConf.BotInfos {
+		if envRegex.MatchString(rawInfo.Token) {
+			conf.BotInfos[i].tokenTmpl = rawInfo.Token
+		}
+	}
+}
+
+// overrideByEnv applies the environment overrides:
+//
+//	LUXTBOT_LOG_LEVEL, LUXTBOT_S_ADMIN (comma separated ids),
+//	LUXTBOT_BOT_<ID>_ACCESS_TOKEN, LUXTBOT_BOT_<ID>_HOST, LUXTBOT_BOT_<ID>_PORT
+func overrideByEnv(conf *Config) error {
+	if val, ok := os.LookupEnv(EnvPrefix + "LOG_LEVEL"); ok {
+		conf.LogConf.Level = val
+	}
+	if val, ok := os.LookupEnv(EnvPrefix + "LOCALE"); ok {
+		conf.Locale = val
+	}
+	if val, ok := os.LookupEnv(EnvPrefix + "S_ADMIN"); ok {
+		conf.SAdmins = conf.SAdmins[:0]
+		for _, idStr := range strings.Split(val, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
+			if err != nil {
+				return errors.New(T("conf.env-id", EnvPrefix, idStr))
+			}
+			conf.SAdmins = append(conf.SAdmins, id)
+		}
+	}
+	for i := range conf.BotInfos {
+		bInfo := &conf.BotInfos[i]
+		prefix := EnvPrefix + "BOT_" + strconv.FormatInt(bInfo.BotID, 10) + "_"
+		if val, ok := os.LookupEnv(prefix + "ACCESS_TOKEN"); ok {
+			bInfo.Token = val
+			bInfo.tokenTmpl = "${" + prefix + "ACCESS_TOKEN}"
+		}
+		if val, ok := os.LookupEnv(prefix + "HOST"); ok {
+			bInfo.Host = val
+		}
+		if val, ok := os.LookupEnv(prefix + "PORT"); ok {
+			port, err := strconv.Atoi(val)
+			if err != nil {
+				return errors.New(T("conf.env-port", prefix, val))
+			}
+			bInfo.Port = port
+		}
+	}
+	return nil
+}
+
+func setConfDefaults(conf *Config) {
+	if conf.CallbackPoolSize <= 0 {
+		conf.CallbackPoolSize = DefaultCallbackPoolSize
+	}
+	if conf.LogConf.Level == "" {
+		conf.LogConf.Level = DefaultLogLevel
+	}
+	if conf.LogConf.MaxFiles <= 0 {
+		conf.LogConf.MaxFiles = DefaultMaxFiles
+	}
+	if conf.DataDir == "" {
+		conf.DataDir = DefaultDataDir
+	}
+	if conf.Locale == "" {
+		conf.Locale = DefaultLocale
+	}
+	if conf.Dedup.Size <= 0 {
+		conf.Dedup.Size = DefaultDedupSize
+	}
+	if conf.Dedup.TTL <= 0 {
+		conf.Dedup.TTL = DefaultDedupTTL
+	}
+	if conf.History.Size <= 0 {
+		conf.History.Size = DefaultHistorySize
+	}
+	if conf.History.FlushInterval <= 0 {
+		conf.History.FlushInterval = DefaultHistoryFlushInterval
+	}
+	if conf.InfoCacheTTL <= 0 {
+		conf.InfoCacheTTL = DefaultInfoCacheTTL
+	}
+	if conf.FailoverCooldown <= 0 {
+		conf.FailoverCooldown = DefaultFailoverCooldown
+	}
+	if conf.ReloadInterval <= 0 {
+		conf.ReloadInterval = DefaultReloadInterval
+	}
+	for i := range conf.BotInfos {
+		setBotInfoDefaults(&conf.BotInfos[i])
+	}
+}
+
+func setBotInfoDefaults(bInfo *BotInfo) {
+	if bInfo.Name == "" {
+		bInfo.Name = strconv.FormatInt(bInfo.BotID, 10)
+	}
+}
+
+func validateConf(conf *Config) error {
+	ids := make(map[int64]bool)
+	for i := range conf.BotInfos {
+		bInfo := &conf.BotInfos[i]
+		if err := validateBotInfo(bInfo); err != nil {
+			return fmt.Errorf("bots[%d].%v", i, err)
+		}
+		if ids[bInfo.BotID] {
+			return errors.New(T("conf.dup-id", i, bInfo.BotID))
+		}
+		ids[bInfo.BotID] = true
+	}
+	if _, err := logrus.ParseLevel(conf.LogConf.Level); err != nil {
+		return errors.New(T("conf.log-level", conf.LogConf.Level))
+	}
+	if conf.Render.Font != "" {
+		if _, err := os.Stat(conf.Render.Font); err != nil {
+			return errors.New(T("conf.font", err))
+		}
+	}
+	if conf.MaxWorkers < 0 {
+		return errors.New(T("conf.negative", "max-workers", conf.MaxWorkers))
+	}
+	if conf.UnitTimeout < 0 {
+		return errors.New(T("conf.negative", "unit-timeout", conf.UnitTimeout))
+	}
+	for role, perms := range conf.Roles {
+		for _, perm := range perms {
+			if perm == "" || perm == "-" {
+				return errors.New(T("conf.role-perm", role))
+			}
+		}
+	}
+	return validateTemplates(conf.Templates)
+}
+
+func validateBotInfo(bInfo *BotInfo) error {
+	switch {
+	case bInfo.BotID <= 0:
+		return errors.New(T("conf.bot-id"))
+	case bInfo.Host == "":
+		return errors.New(T("conf.host"))
+	case bInfo.Port <= 0 || bInfo.Port > 65535:
+		return errors.New(T("conf.port", bInfo.Port))
+	case bInfo.MessageType != MsgTypeArray && bInfo.MessageType != MsgTypeString:
+		return errors.New(T("conf.msg-type", MsgTypeArray, MsgTypeString, bInfo.MessageType))
+	case bInfo.Timeout < 0:
+		return errors.New(T("conf.negative", "time-out", bInfo.Timeout))
+	case bInfo.MaxMsgLen < 0:
+		return errors.New(T("conf.negative", "max-msg-len", bInfo.MaxMsgLen))
+	case bInfo.ForwardLines < 0:
+		return errors.New(T("conf.negative", "forward-lines", bInfo.ForwardLines))
+	}
+	return nil
+}
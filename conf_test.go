@@ -0,0 +1,162 @@
+package luxtbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// setEnv sets the environment variable until the test ends.
+func setEnv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestExpandEnv(t *testing.T) {
+	setEnv(t, "LUXTBOT_TEST_TOKEN", "secret")
+	tests := []struct {
+		name   string
+		format string
+		data   string
+		want   string
+		fail   bool
+	}{
+		{"value", ConfFormatYAML, "access-token: ${LUXTBOT_TEST_TOKEN}\n", "access-token: secret\n", false},
+		{"in a string", ConfFormatYAML, `host: "a-${LUXTBOT_TEST_TOKEN}"`, `host: "a-secret"`, false},
+		{"undefined", ConfFormatYAML, "access-token: ${LUXTBOT_TEST_UNDEFINED}\n", "", true},
+		{"comment line", ConfFormatYAML, "# access-token: ${LUXTBOT_TEST_UNDEFINED}\nport: 1\n", "# access-token: ${LUXTBOT_TEST_UNDEFINED}\nport: 1\n", false},
+		{"indented comment", ConfFormatYAML, "bots:\n  # ${LUXTBOT_TEST_UNDEFINED}\n", "bots:\n  # ${LUXTBOT_TEST_UNDEFINED}\n", false},
+		{"trailing comment", ConfFormatYAML, "a: ${LUXTBOT_TEST_TOKEN} # ${LUXTBOT_TEST_UNDEFINED}", "a: secret # ${LUXTBOT_TEST_UNDEFINED}", false},
+		{"# in quotes", ConfFormatYAML, `a: "x #${LUXTBOT_TEST_TOKEN}"`, `a: "x #secret"`, false},
+		{"# in single quotes", ConfFormatYAML, `a: 'x #${LUXTBOT_TEST_TOKEN}'`, `a: 'x #secret'`, false},
+		{"# in a word", ConfFormatYAML, "a: x#${LUXTBOT_TEST_TOKEN}", "a: x#secret", false},
+		{"apostrophe", ConfFormatYAML, "a: it's # ${LUXTBOT_TEST_UNDEFINED}", "a: it's # ${LUXTBOT_TEST_UNDEFINED}", false},
+		{"toml comment", ConfFormatTOML, "# ${LUXTBOT_TEST_UNDEFINED}\na = \"${LUXTBOT_TEST_TOKEN}\"", "# ${LUXTBOT_TEST_UNDEFINED}\na = \"secret\"", false},
+		{"json has no comments", ConfFormatJSON, `{"a": "# ${LUXTBOT_TEST_TOKEN}"}`, `{"a": "# secret"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandEnv([]byte(tt.data), tt.format)
+			if tt.fail {
+				if err == nil {
+					t.Errorf("expandEnv(%q) = %q, want an error", tt.data, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("expandEnv(%q) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConf(t *testing.T) {
+	setEnv(t, "LUXTBOT_TEST_TOKEN", "secret")
+	setEnv(t, EnvPrefix+"BOT_11_PORT", "6711")
+	setEnv(t, EnvPrefix+"S_ADMIN", "1, 2")
+	want := []BotInfo{
+		{BotID: 10, Host: "127.0.0.1", Port: 6700, Name: "a", Token: "secret", MessageType: MsgTypeArray, tokenTmpl: "${LUXTBOT_TEST_TOKEN}"},
+		{BotID: 11, Host: "127.0.0.1", Port: 6711, Name: "11", MessageType: MsgTypeString},
+	}
+	tests := []struct {
+		file    string
+		content string
+	}{
+		{"config.yml", `# ${LUXTBOT_TEST_UNDEFINED} in a comment
+s-admin: [3]
+bots:
+  - id: 10
+    host: 127.0.0.1
+    port: 6700
+    name: a
+    access-token: ${LUXTBOT_TEST_TOKEN}
+    message-type: array
+  - id: 11
+    host: 127.0.0.1
+    port: 6701
+    message-type: string
+`},
+		{"config.json", `{
+  "s-admin": [3],
+  "bots": [
+    {"id": 10, "host": "127.0.0.1", "port": 6700, "name": "a", "access-token": "${LUXTBOT_TEST_TOKEN}", "message-type": "array"},
+    {"id": 11, "host": "127.0.0.1", "port": 6701, "message-type": "string"}
+  ]
+}`},
+		{"config.toml", `# ${LUXTBOT_TEST_UNDEFINED} in a comment
+s-admin = [3]
+
+[[bots]]
+id = 10
+host = "127.0.0.1"
+port = 6700
+name = "a"
+access-token = "${LUXTBOT_TEST_TOKEN}"
+message-type = "array"
+
+[[bots]]
+id = 11
+host = "127.0.0.1"
+port = 6701
+message-type = "string"
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			conf, err := loadConf(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(conf.BotInfos, want) {
+				t.Errorf("bots = %+v, want %+v", conf.BotInfos, want)
+			}
+			if !reflect.DeepEqual(conf.SAdmins, []int64{1, 2}) {
+				t.Errorf("s-admin = %v, want [1 2]", conf.SAdmins)
+			}
+			if conf.LogConf.Level != DefaultLogLevel || conf.DataDir != DefaultDataDir {
+				t.Errorf("the defaults are not applied: %+v", conf)
+			}
+		})
+	}
+}
+
+func TestLoadConfInvalid(t *testing.T) {
+	tests := []struct {
+		file    string
+		content string
+	}{
+		{"config.ini", "a = 1"},
+		{"config.yml", "unknown-key: 1\n"},
+		{"config.yml", "bots:\n  - id: 10\n    host: 127.0.0.1\n    port: 0\n    message-type: array\n"},
+		{"config.yml", "bots:\n  - id: 10\n    host: 127.0.0.1\n    port: 1\n    message-type: xml\n"},
+		{"config.yml", "log:\n  level: LOUD\n"},
+		{"config.yml", "rules:\n  p: group in [\n"},
+		{"config.json", `{"bots": [}`},
+		{"config.toml", "bots = ["},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), tt.file)
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConf(path); err == nil {
+			t.Errorf("loadConf(%q) of %q succeeded", tt.file, tt.content)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package luxtbot
 
 import (
-	"errors"
-	"reflect"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	lutil "github.com/ABiao0306/luxtbot/util"
@@ -16,6 +18,7 @@ const (
 const (
 	GroupMsgAction   = "send_group_msg"
 	PrivateMsgAction = "send_private_msg"
+	SendMsgAction    = "send_msg"
 )
 
 const (
@@ -35,42 +38,43 @@ const (
 )
 
 type Event struct {
-	Font          int         `json:"font"`
-	GroupID       int64       `json:"group_id"`
-	Message       interface{} `json:"message"`
-	MessageID     int         `json:"message_id"`
-	MessageSeq    int         `json:"message_seq"`
-	MessageType   string      `json:"message_type"`
-	PostType      string      `json:"post_type"`
-	RawMessage    string      `json:"raw_message"`
-	TempSource    int         `json:"temp_source"`
-	SelfID        int64       `json:"self_id"`
-	Sender        Sender      `json:"sender"`
-	SubType       string      `json:"sub_type"`
-	Time          int         `json:"time"`
-	UserID        int64       `json:"user_id"`
-	MetaEventType string      `json:"meta_event_type"`
-}
-
-var (
-	reflectTypeOfString  = reflect.TypeOf("")
-	reflectTypeOfMsgSegs = reflect.TypeOf([]MsgSeg{})
-)
+	Font          int     `json:"font"`
+	GroupID       int64   `json:"group_id"`
+	Message       Message `json:"message"`
+	MessageID     int     `json:"message_id"`
+	MessageSeq    int     `json:"message_seq"`
+	MessageType   string  `json:"message_type"`
+	PostType      string  `json:"post_type"`
+	RawMessage    string  `json:"raw_message"`
+	TempSource    int     `json:"temp_source"`
+	SelfID        int64   `json:"self_id"`
+	Sender        Sender  `json:"sender"`
+	SubType       string  `json:"sub_type"`
+	Time          int     `json:"time"`
+	UserID        int64   `json:"user_id"`
+	MetaEventType string  `json:"meta_event_type"`
+	NoticeType    string  `json:"notice_type"`
+	RequestType   string  `json:"request_type"`
+	OperatorID    int64   `json:"operator_id"`
+	// the cards of group_card notices
+	CardNew string `json:"card_new"`
+	CardOld string `json:"card_old"`
+
+	// the match and the groups captured by the Regex judge of the rule,
+	// every unit gets its own copy of the event.
+	Matches []string `json:"-"`
+
+	ctx context.Context
+}
 
+// GetArrayMsg returns the segments of the message.
 func (e *Event) GetArrayMsg() []MsgSeg {
-	if reflect.TypeOf(e.Message) == reflectTypeOfString {
-		msgSegs := ParseMsgSegs(e.Message.(string))
-		return msgSegs
-	}
-	return e.Message.([]MsgSeg)
+	return e.Message
 }
 
+// GetTextMsg returns the string form of the message.
 func (e *Event) GetTextMsg() string {
-	if reflect.TypeOf(e.Message) == reflectTypeOfString {
-		return e.Message.(string)
-	}
-	text := ParseTextMsg(e.Message.([]MsgSeg))
-	return text
+	return e.Message.String()
 }
 
 type Sender struct {
@@ -107,7 +111,40 @@ func (api ApiPost) Do(botID int64, needEcho bool) (string, error) {
 	case bCtx.OutChan <- api:
 		return api.Echo, nil
 	case <-time.After(time.Second * 5):
-		return "", errors.New("发送消息超时。")
+		return "", ErrSendTimeout
+	}
+}
+
+// DoWithResp sends the api and waits for the response until timeout,
+// a timeout <= 0 means DefaultApiTimeout.
+// The error of a failed call is returned along with the response.
+func (api ApiPost) DoWithResp(botID int64, timeout time.Duration) (*ApiResp, error) {
+	if timeout <= 0 {
+		timeout = DefaultApiTimeout
+	}
+	api.Echo = lutil.GetEchoStr()
+	respChan := make(chan *ApiResp, 1)
+	AddEchoCallback(api.Echo, func(apiResp *ApiResp, bInfo BotInfo) {
+		respChan <- apiResp
+	})
+	bCtx, err := getBotCtxByID(botID)
+	if err != nil {
+		removeEchoCallback(api.Echo)
+		return nil, err
+	}
+	deadline := time.After(timeout)
+	select {
+	case bCtx.OutChan <- api:
+	case <-deadline:
+		removeEchoCallback(api.Echo)
+		return nil, ErrSendTimeout
+	}
+	select {
+	case resp := <-respChan:
+		return resp, resp.Err()
+	case <-deadline:
+		removeEchoCallback(api.Echo)
+		return nil, ErrRespTimeout
 	}
 }
 
@@ -124,7 +161,7 @@ type PrivateMsg struct {
 	Message    interface{} `json:"message"`
 	AutoEscape bool        `json:"auto_escape"`
 	UserID     int64       `json:"user_id"`
-	GroupID    int64       `json:"group_id"`
+	GroupID    int64       `json:"group_id,omitempty"`
 }
 
 type GroupMsg struct {
@@ -134,18 +171,67 @@ type GroupMsg struct {
 }
 
 type ApiResp struct {
+	// the error of an api never sent, such as one dropped by the filter
+	err  error
 	Data struct {
 		MessageID int `json:"message_id"`
 	} `json:"data"`
-	Echo    string `jons:"echo"`
-	Retcode int    `json:"retcode"`
-	Status  string `json:"status"`
+	// the data as it is, which could be any JSON value, see DecodeData
+	RawData json.RawMessage `json:"-"`
+	Echo    string          `json:"echo"`
+	Retcode int             `json:"retcode"`
+	Status  string          `json:"status"`
+	Msg     string          `json:"msg"`
+	Wording string          `json:"wording"`
+}
+
+func (resp *ApiResp) UnmarshalJSON(data []byte) error {
+	type apiResp ApiResp
+	raw := struct {
+		*apiResp
+		RawData json.RawMessage `json:"data"`
+	}{apiResp: (*apiResp)(resp)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	resp.RawData = raw.RawData
+	if len(raw.RawData) > 0 && raw.RawData[0] == '{' {
+		return json.Unmarshal(raw.RawData, &resp.Data)
+	}
+	return nil
+}
+
+// DecodeData decodes the data of the response into v.
+func (resp *ApiResp) DecodeData(v interface{}) error {
+	if len(resp.RawData) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.RawData, v)
+}
+
+const (
+	RespStatusOK     = "ok"
+	RespStatusAsync  = "async"
+	RespStatusFailed = "failed"
+
+	DefaultApiTimeout = time.Second * 10
+)
+
+// Err returns an error if the api call is failed.
+func (resp *ApiResp) Err() error {
+	if resp.err != nil {
+		return resp.err
+	}
+	if resp.Status == RespStatusFailed || resp.Retcode != 0 && resp.Status != RespStatusAsync {
+		return fmt.Errorf("%w: retcode=%d, %v %v", ErrApiFailed, resp.Retcode, resp.Msg, resp.Wording)
+	}
+	return nil
 }
 
 func MakeGroupMsg(msgBuilder MsgBuilder, groupID int64) ApiPost {
 	msgData, err := msgBuilder.GetMsg()
 	if err != nil {
-		LBLogger.WithField("目标群", groupID).Infof("检查到消息异常，将放弃该条消息：%v", err)
+		LBLogger.WithField("GroupID", groupID).Infoln(T("msg.dropped", err))
 	}
 	msg := &GroupMsg{
 		GroupID:    groupID,
@@ -155,10 +241,17 @@ func MakeGroupMsg(msgBuilder MsgBuilder, groupID int64) ApiPost {
 	return makeApi(GroupMsgAction, msg)
 }
 
+// MakeTempMsg makes a private message to a group member who is not a friend.
+func MakeTempMsg(msgBuilder MsgBuilder, userID, groupID int64) ApiPost {
+	api := MakePrivateMsg(msgBuilder, userID)
+	api.Params.(*PrivateMsg).GroupID = groupID
+	return api
+}
+
 func MakePrivateMsg(msgBuilder MsgBuilder, userID int64) ApiPost {
 	msgData, err := msgBuilder.GetMsg()
 	if err != nil {
-		LBLogger.WithField("目标QQ", userID).Infof("检查到消息异常，将放弃该条消息：%v", err)
+		LBLogger.WithField("UserID", userID).Infoln(T("msg.dropped", err))
 	}
 	msg := &PrivateMsg{
 		UserID:     userID,
@@ -167,3 +260,90 @@ func MakePrivateMsg(msgBuilder MsgBuilder, userID int64) ApiPost {
 	}
 	return makeApi(PrivateMsgAction, msg)
 }
+
+// normalizeMsgApi makes the params of the apis sending messages pointers to
+// GroupMsg, PrivateMsg or ForwardMsg, so that the hooks could inspect the
+// message, and send_msg becomes send_group_msg or send_private_msg. The
+// other shapes of params, such as maps, are decoded from their JSON.
+// It reports false if the params of a message api could not be decoded.
+func normalizeMsgApi(api *ApiPost) bool {
+	action, suffix := api.Action, ""
+	for _, s := range []string{"_async", "_rate_limited"} {
+		if strings.HasSuffix(action, s) {
+			action, suffix = strings.TrimSuffix(action, s), s
+		}
+	}
+	switch action {
+	case SendMsgAction, GroupMsgAction, PrivateMsgAction, GroupForwardMsgAction, PrivateForwardMsgAction:
+	default:
+		return true
+	}
+	switch params := api.Params.(type) {
+	case *GroupMsg, *PrivateMsg, *ForwardMsg:
+		return true
+	case GroupMsg:
+		api.Params = &params
+		return true
+	case PrivateMsg:
+		api.Params = &params
+		return true
+	case ForwardMsg:
+		api.Params = &params
+		return true
+	}
+	data, err := json.Marshal(api.Params)
+	if err != nil {
+		return false
+	}
+	var raw struct {
+		MessageType string          `json:"message_type"`
+		GroupID     int64           `json:"group_id"`
+		UserID      int64           `json:"user_id"`
+		Message     json.RawMessage `json:"message"`
+		Messages    Message         `json:"messages"`
+		AutoEscape  bool            `json:"auto_escape"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return false
+	}
+	if action == GroupForwardMsgAction || action == PrivateForwardMsgAction {
+		api.Params = &ForwardMsg{GroupID: raw.GroupID, UserID: raw.UserID, Messages: raw.Messages}
+		return true
+	}
+	msg, ok := decodeMsgData(raw.Message)
+	if !ok {
+		return false
+	}
+	isGroup := action == GroupMsgAction ||
+		action == SendMsgAction && (raw.MessageType == MsgTypeGroup || raw.MessageType == "" && raw.GroupID != 0)
+	if isGroup {
+		api.Params = &GroupMsg{GroupID: raw.GroupID, Message: msg, AutoEscape: raw.AutoEscape}
+		if action == SendMsgAction {
+			api.Action = GroupMsgAction + suffix
+		}
+		return true
+	}
+	api.Params = &PrivateMsg{UserID: raw.UserID, GroupID: raw.GroupID, Message: msg, AutoEscape: raw.AutoEscape}
+	if action == SendMsgAction {
+		api.Action = PrivateMsgAction + suffix
+	}
+	return true
+}
+
+// decodeMsgData decodes a message in the string form, the array form,
+// or a single segment.
+func decodeMsgData(raw json.RawMessage) (interface{}, bool) {
+	var str string
+	if json.Unmarshal(raw, &str) == nil {
+		return str, true
+	}
+	var msg Message
+	if json.Unmarshal(raw, &msg) == nil {
+		return msg, true
+	}
+	var seg MsgSeg
+	if json.Unmarshal(raw, &seg) == nil && seg.Type != "" {
+		return Message{seg}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,156 @@
+package luxtbot
+
+import (
+	"sort"
+	"strings"
+)
+
+const cqPrefix = "[CQ:"
+
+var (
+	cqTextEscaper  = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;")
+	cqParamEscaper = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;", ",", "&#44;")
+	cqUnescaper    = strings.NewReplacer("&#91;", "[", "&#93;", "]", "&#44;", ",", "&amp;", "&")
+	// keys also escape "=", which is not in the CQ code spec but never seen in real keys
+	cqKeyEscaper   = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;", ",", "&#44;", "=", "&#61;")
+	cqKeyUnescaper = strings.NewReplacer("&#91;", "[", "&#93;", "]", "&#44;", ",", "&#61;", "=", "&amp;", "&")
+)
+
+// EscapeCQText escapes the plain text out of CQ codes: & [ ]
+func EscapeCQText(s string) string {
+	return cqTextEscaper.Replace(s)
+}
+
+// EscapeCQParam escapes the parameter value in CQ codes: & [ ] ,
+func EscapeCQParam(s string) string {
+	return cqParamEscaper.Replace(s)
+}
+
+// UnescapeCQ reverts both EscapeCQText and EscapeCQParam.
+func UnescapeCQ(s string) string {
+	return cqUnescaper.Replace(s)
+}
+
+// EncodeMsgSeg encodes a segment to its string form,
+// the parameters are sorted by key so the result is stable.
+// An empty text segment, or a segment of an invalid type such as "",
+// has no string form and is encoded to "".
+func EncodeMsgSeg(seg MsgSeg) string {
+	var sb strings.Builder
+	writeMsgSeg(&sb, seg)
+	return sb.String()
+}
+
+// EncodeMsgSegs encodes segments to a string message. ParseMsgSegs gets
+// the segments back, except that adjacent text segments are merged and
+// the segments encoded to "" are dropped.
+func EncodeMsgSegs(segs []MsgSeg) string {
+	var sb strings.Builder
+	for _, seg := range segs {
+		writeMsgSeg(&sb, seg)
+	}
+	return sb.String()
+}
+
+func writeMsgSeg(sb *strings.Builder, seg MsgSeg) {
+	if seg.Type == TextMsgSeg {
+		sb.WriteString(EscapeCQText(seg.Data["text"]))
+		return
+	}
+	if !isCQType(seg.Type) {
+		return
+	}
+	keys := make([]string, 0, len(seg.Data))
+	for k := range seg.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sb.WriteString(cqPrefix)
+	sb.WriteString(seg.Type)
+	for _, k := range keys {
+		sb.WriteByte(',')
+		sb.WriteString(cqKeyEscaper.Replace(k))
+		sb.WriteByte('=')
+		sb.WriteString(EscapeCQParam(seg.Data[k]))
+	}
+	sb.WriteByte(']')
+}
+
+// ParseMsgSegs parses a string message into segments.
+// Text out of CQ codes becomes text segments, adjacent text is merged,
+// and a broken CQ code is kept as text rather than dropped.
+func ParseMsgSegs(msg string) []MsgSeg {
+	segs := make([]MsgSeg, 0, 4)
+	var text strings.Builder
+	flushText := func() {
+		if text.Len() == 0 {
+			return
+		}
+		segs = append(segs, MsgSeg{
+			Type: TextMsgSeg,
+			Data: map[string]string{"text": UnescapeCQ(text.String())},
+		})
+		text.Reset()
+	}
+	for len(msg) > 0 {
+		i := strings.Index(msg, cqPrefix)
+		if i < 0 {
+			text.WriteString(msg)
+			break
+		}
+		text.WriteString(msg[:i])
+		msg = msg[i:]
+		seg, n, ok := lexCQCode(msg)
+		if !ok {
+			// not a CQ code, keep "[" as text and go on
+			text.WriteByte('[')
+			msg = msg[1:]
+			continue
+		}
+		flushText()
+		segs = append(segs, seg)
+		msg = msg[n:]
+	}
+	flushText()
+	return segs
+}
+
+// lexCQCode reads one CQ code at the beginning of s,
+// returns the segment and the length it consumed.
+func lexCQCode(s string) (MsgSeg, int, bool) {
+	var seg MsgSeg
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return seg, 0, false
+	}
+	body := s[len(cqPrefix):end]
+	if strings.IndexByte(body, '[') >= 0 {
+		return seg, 0, false
+	}
+	fields := strings.Split(body, ",")
+	if !isCQType(fields[0]) {
+		return seg, 0, false
+	}
+	seg.Type = fields[0]
+	seg.Data = make(map[string]string, len(fields)-1)
+	for _, field := range fields[1:] {
+		eq := strings.IndexByte(field, '=')
+		if eq < 0 {
+			return seg, 0, false
+		}
+		seg.Data[cqKeyUnescaper.Replace(field[:eq])] = UnescapeCQ(field[eq+1:])
+	}
+	return seg, end + 1, true
+}
+
+func isCQType(t string) bool {
+	if t == "" {
+		return false
+	}
+	for _, c := range t {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,32 @@
+//go:build go1.18
+// +build go1.18
+
+package luxtbot
+
+import (
+	"reflect"
+	"testing"
+)
+
+// FuzzParseMsgSegs checks that whatever is parsed is encoded and parsed back
+// to the same segments.
+func FuzzParseMsgSegs(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"hello",
+		"[CQ:face,id=1]",
+		"a[CQ:at,qq=all]b[CQ:image,file=a&#44;b,url=]",
+		"[CQ:json,a&#61;b=1,c&#44;d=&#91;&#93;]",
+		"[CQ:,=]",
+		"&amp;#91;[CQ:[CQ:face]",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, msg string) {
+		segs := ParseMsgSegs(msg)
+		encoded := EncodeMsgSegs(segs)
+		if got := ParseMsgSegs(encoded); !reflect.DeepEqual(got, segs) {
+			t.Errorf("ParseMsgSegs(%q) = %v, encoded to %q and parsed to %v", msg, segs, encoded, got)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package luxtbot
+
+import (
+	"reflect"
+	"testing"
+)
+
+// normalizeSegs merges adjacent text and drops what has no string form,
+// which is what ParseMsgSegs(EncodeMsgSegs(segs)) is expected to return.
+func normalizeSegs(segs []MsgSeg) []MsgSeg {
+	norm := make([]MsgSeg, 0, len(segs))
+	for _, seg := range segs {
+		if seg.Type == TextMsgSeg {
+			text := seg.Data["text"]
+			if text == "" {
+				continue
+			}
+			if n := len(norm); n > 0 && norm[n-1].Type == TextMsgSeg {
+				text = norm[n-1].Data["text"] + text
+				norm = norm[:n-1]
+			}
+			norm = append(norm, MsgSeg{Type: TextMsgSeg, Data: map[string]string{"text": text}})
+			continue
+		}
+		if !isCQType(seg.Type) {
+			continue
+		}
+		data := make(map[string]string, len(seg.Data))
+		for k, v := range seg.Data {
+			data[k] = v
+		}
+		norm = append(norm, MsgSeg{Type: seg.Type, Data: data})
+	}
+	return norm
+}
+
+func TestCQCodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		segs []MsgSeg
+	}{
+		{"text", []MsgSeg{{Type: TextMsgSeg, Data: map[string]string{"text": "a & [b], c=d &amp; &#91;"}}}},
+		{"at", []MsgSeg{
+			{Type: TextMsgSeg, Data: map[string]string{"text": "hi "}},
+			{Type: AtMsgSeg, Data: map[string]string{"qq": "123"}},
+		}},
+		{"escaped value", []MsgSeg{{Type: ImageMsgSeg, Data: map[string]string{"file": "a,b[c]&d=e", "url": ""}}}},
+		{"escaped key", []MsgSeg{{Type: JsonMsgSeg, Data: map[string]string{"a=b": "1", "c,d": "2", "[e]": "3", "&#61;": "4", "": "5"}}}},
+		{"no data", []MsgSeg{{Type: ShakeMsgSeg}}},
+		{"empty text", []MsgSeg{
+			{Type: TextMsgSeg, Data: map[string]string{"text": ""}},
+			{Type: FaceMsgSeg, Data: map[string]string{"id": "1"}},
+			{Type: TextMsgSeg},
+		}},
+		{"adjacent text", []MsgSeg{
+			{Type: TextMsgSeg, Data: map[string]string{"text": "a"}},
+			{Type: TextMsgSeg, Data: map[string]string{"text": "b"}},
+		}},
+		{"empty type", []MsgSeg{
+			{Type: "", Data: map[string]string{"qq": "all"}},
+			{Type: TextMsgSeg, Data: map[string]string{"text": "x"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := EncodeMsgSegs(tt.segs)
+			got := ParseMsgSegs(encoded)
+			if want := normalizeSegs(tt.segs); !reflect.DeepEqual(got, want) {
+				t.Errorf("ParseMsgSegs(%q) = %v, want %v", encoded, got, want)
+			}
+		})
+	}
+}
+
+func TestParseMsgSegs(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want []MsgSeg
+	}{
+		{"", []MsgSeg{}},
+		{"[CQ:face,id=1]", []MsgSeg{{Type: FaceMsgSeg, Data: map[string]string{"id": "1"}}}},
+		{"a[CQ:at,qq=1]b", []MsgSeg{
+			{Type: TextMsgSeg, Data: map[string]string{"text": "a"}},
+			{Type: AtMsgSeg, Data: map[string]string{"qq": "1"}},
+			{Type: TextMsgSeg, Data: map[string]string{"text": "b"}},
+		}},
+		// broken codes are kept as text
+		{"[CQ:face", []MsgSeg{{Type: TextMsgSeg, Data: map[string]string{"text": "[CQ:face"}}}},
+		{"[CQ:,id=1]", []MsgSeg{{Type: TextMsgSeg, Data: map[string]string{"text": "[CQ:,id=1]"}}}},
+		{"[CQ:face,id]", []MsgSeg{{Type: TextMsgSeg, Data: map[string]string{"text": "[CQ:face,id]"}}}},
+	}
+	for _, tt := range tests {
+		if got := ParseMsgSegs(tt.msg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMsgSegs(%q) = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package luxtbot
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Ctx is what a unit handler gets for an event. It is a context.Context
+// which is done when the timeout of the unit is reached.
+type Ctx struct {
+	context.Context
+	Event *Event
+	// the bot receiving the event, it is live, so check IsReady before using the connection.
+	Bot    *BotContext
+	Plugin *Plugin
+	// the params of a command, nil for other units
+	Args []string
+	// the match and the groups captured by the Regex judge of the rule
+	Matches []string
+	// logger with the bot, plugin and unit fields
+	Log *logrus.Entry
+}
+
+func newCtx(ctx context.Context, e *Event, bCtx *BotContext, plg *Plugin, unit string, args []string) *Ctx {
+	return &Ctx{
+		Context: ctx,
+		Event:   e,
+		Bot:     bCtx,
+		Plugin:  plg,
+		Args:    args,
+		Matches: e.Matches,
+		Log:     LBLogger.WithField("BotName", bCtx.Info().Name).WithField("PluginID", plg.ID).WithField("Unit", unit),
+	}
+}
+
+// BotInfo returns the info of the bot receiving the event.
+func (c *Ctx) BotInfo() BotInfo {
+	return *c.Bot.Info()
+}
+
+// Reply replies to the event, see Event.Reply.
+func (c *Ctx) Reply(msg MsgBuilder, opts ...ReplyOption) (int, error) {
+	return c.Event.Reply(c.BotInfo(), msg, opts...)
+}
+
+// ReplyText replies a text message to the event.
+func (c *Ctx) ReplyText(text string, opts ...ReplyOption) (int, error) {
+	return c.Reply(MakeArrayMsg(1).AddText(text), opts...)
+}
+
+// ReplyTmpl replies the template of key of the plugin rendered with data.
+func (c *Ctx) ReplyTmpl(key string, data interface{}, opts ...ReplyOption) (int, error) {
+	msg, err := c.Plugin.Render(key, data)
+	if err != nil {
+		return 0, err
+	}
+	return c.Reply(msg, opts...)
+}
+
+// Call sends the api by the bot and waits for the response,
+// until the deadline of the context or DefaultApiTimeout.
+func (c *Ctx) Call(api ApiPost) (*ApiResp, error) {
+	timeout := DefaultApiTimeout
+	if deadline, ok := c.Deadline(); ok {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return nil, c.Err()
+		}
+	}
+	return api.DoWithResp(c.Bot.Info().BotID, timeout)
+}
+
+// QuotedMsg returns the message quoted by the event from the history, see Event.QuotedMsg.
+func (c *Ctx) QuotedMsg() (HistoryMsg, bool) {
+	return c.Event.QuotedMsg(c.BotInfo())
+}
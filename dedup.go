@@ -0,0 +1,173 @@
+package luxtbot
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	lutil "github.com/ABiao0306/luxtbot/util"
+)
+
+const (
+	DefaultDedupSize = 4096
+	DefaultDedupTTL  = 300
+
+	dedupHookName = "luxtbot.dedup"
+)
+
+// ErrEventDuplicated is returned by the dedup middleware for the events
+// received again, or the group messages handled by another bot.
+const ErrEventDuplicated Error = "err.event-dup"
+
+// DedupConf drops the messages received more than once by a bot, which
+// happens when go-cqhttp reconnects. With single-bot, a group message is
+// handled by only one of the bots in the group, the leader, which is the one
+// of leaders, or else the first online bot of the config seen in the group
+// within the ttl. As the leader could change when a bot is seen for the
+// first time, a group message handled by a bot is never handled again by
+// another one.
+type DedupConf struct {
+	Enable bool `yaml:"enable"`
+	// count of the messages remembered, 4096 by default
+	Size int `yaml:"size"`
+	// seconds of a message remembered, and of a bot seen in a group, 300 by default
+	TTL       int  `yaml:"ttl"`
+	SingleBot bool `yaml:"single-bot"`
+	// group id -> bot id, the bot always handling the group when it is online
+	Leaders map[int64]int64 `yaml:"leaders,omitempty"`
+}
+
+var (
+	dedupCache *lutil.LRU
+	dedupLock  sync.RWMutex
+
+	// group id -> bot id -> the last time the bot received a message of the group
+	groupSeen     = make(map[int64]map[int64]time.Time)
+	groupSeenLock sync.Mutex
+)
+
+func init() {
+	UseEventIn(dedupHookName, PriorityFirst+1, func(c *EventInCtx, next func() error) error {
+		if err := dedupEvent(c.Event, c.BotInfo()); err != nil {
+			return err
+		}
+		return next()
+	})
+}
+
+func dedupTTL() time.Duration {
+	return time.Duration(CurConf().Dedup.TTL) * time.Second
+}
+
+// initDedup makes the cache by the config, the messages remembered are dropped.
+func initDedup() {
+	dedupLock.Lock()
+	defer dedupLock.Unlock()
+	dedupCache = lutil.NewLRU(CurConf().Dedup.Size, dedupTTL())
+}
+
+func dedupKey(e *Event) string {
+	id := e.MessageID
+	if id == 0 {
+		id = e.MessageSeq
+	}
+	return fmt.Sprintf("%d:%d:%d", e.SelfID, id, e.Time)
+}
+
+// groupMsgKey is the key of a group message shared by all of the bots
+// receiving it, as the message_ids differ among them.
+func groupMsgKey(e *Event) string {
+	h := fnv.New64a()
+	h.Write([]byte(e.RawMessage))
+	return fmt.Sprintf("g%d:%d:%d:%x", e.GroupID, e.UserID, e.Time, h.Sum64())
+}
+
+func dedupEvent(e *Event, bInfo BotInfo) error {
+	if e.PostType != MessageEvent {
+		return nil
+	}
+	isGroup := e.MessageType == MsgTypeGroup && e.GroupID != 0
+	if isGroup {
+		seeGroup(e.GroupID, bInfo.BotID)
+	}
+	if !CurConf().Dedup.Enable {
+		return nil
+	}
+	dedupLock.RLock()
+	cache := dedupCache
+	dedupLock.RUnlock()
+	if cache == nil {
+		return nil
+	}
+	if (e.MessageID != 0 || e.MessageSeq != 0) && cache.Add(dedupKey(e), nil) {
+		return fmt.Errorf("%w: %d", ErrEventDuplicated, e.MessageID)
+	}
+	if CurConf().Dedup.SingleBot && isGroup {
+		if leader := GroupLeader(e.GroupID); leader != 0 && leader != bInfo.BotID {
+			return fmt.Errorf("%w: %d", ErrEventDuplicated, e.MessageID)
+		}
+		// taken by the bot handling it, even if it is not the leader later
+		if cache.Add(groupMsgKey(e), nil) {
+			return fmt.Errorf("%w: %d", ErrEventDuplicated, e.MessageID)
+		}
+	}
+	return nil
+}
+
+func seeGroup(groupID, botID int64) {
+	groupSeenLock.Lock()
+	defer groupSeenLock.Unlock()
+	seen := groupSeen[groupID]
+	if seen == nil {
+		seen = make(map[int64]time.Time)
+		groupSeen[groupID] = seen
+	}
+	seen[botID] = time.Now()
+}
+
+// GroupBots returns the online bots in the group by the info cache,
+// or seen in the group within the ttl of dedup.
+func GroupBots(groupID int64) []int64 {
+	deadline := time.Now().Add(-dedupTTL())
+	groupSeenLock.Lock()
+	seen := make(map[int64]bool)
+	for botID, t := range groupSeen[groupID] {
+		if t.After(deadline) {
+			seen[botID] = true
+		}
+	}
+	groupSeenLock.Unlock()
+	var botIDs []int64
+	botsLock.RLock()
+	defer botsLock.RUnlock()
+	for _, bCtx := range bots {
+		if (seen[bCtx.Info().BotID] || bCtx.InGroup(groupID)) && botReady(bCtx) {
+			botIDs = append(botIDs, bCtx.Info().BotID)
+		}
+	}
+	return botIDs
+}
+
+// GroupLeader returns the bot handling the messages of the group with
+// single-bot of dedup, 0 if no bot is seen in the group.
+func GroupLeader(groupID int64) int64 {
+	botIDs := GroupBots(groupID)
+	if pinned, ok := CurConf().Dedup.Leaders[groupID]; ok {
+		for _, botID := range botIDs {
+			if botID == pinned {
+				return pinned
+			}
+		}
+	}
+	if len(botIDs) == 0 {
+		return 0
+	}
+	return botIDs[0]
+}
+
+func botReady(bCtx *BotContext) bool {
+	bCtx.CloseLock.Lock()
+	defer bCtx.CloseLock.Unlock()
+	return bCtx.IsReady
+}
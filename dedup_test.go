@@ -0,0 +1,154 @@
+package luxtbot
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// useBots registers ready bots of the ids until the test ends.
+func useBots(t *testing.T, botIDs ...int64) {
+	botsLock.Lock()
+	old := bots
+	bots = nil
+	for _, botID := range botIDs {
+		bCtx := newBotCtx(&BotInfo{BotID: botID, Name: "bot"})
+		bCtx.IsReady = true
+		bots = append(bots, bCtx)
+	}
+	botsLock.Unlock()
+	t.Cleanup(func() {
+		botsLock.Lock()
+		bots = old
+		botsLock.Unlock()
+	})
+}
+
+// useDedup enables dedup with a new cache and no group seen until the test ends.
+func useDedup(t *testing.T, singleBot bool, leaders map[int64]int64) {
+	conf := *CurConf()
+	conf.Dedup = DedupConf{Enable: true, Size: DefaultDedupSize, TTL: DefaultDedupTTL, SingleBot: singleBot, Leaders: leaders}
+	useConf(t, conf)
+	initDedup()
+	groupSeenLock.Lock()
+	groupSeen = make(map[int64]map[int64]time.Time)
+	groupSeenLock.Unlock()
+}
+
+func groupMsg(selfID int64, messageID int, raw string) *Event {
+	return &Event{
+		PostType:    MessageEvent,
+		MessageType: MsgTypeGroup,
+		SelfID:      selfID,
+		GroupID:     100,
+		UserID:      1,
+		MessageID:   messageID,
+		RawMessage:  raw,
+		Time:        1700000000,
+	}
+}
+
+type dedupStep struct {
+	botID   int64
+	e       *Event
+	handled bool
+}
+
+func runDedupSteps(t *testing.T, steps []dedupStep) {
+	t.Helper()
+	for i, step := range steps {
+		err := dedupEvent(step.e, BotInfo{BotID: step.botID})
+		if err != nil && !errors.Is(err, ErrEventDuplicated) {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if handled := err == nil; handled != step.handled {
+			t.Errorf("step %d: bot %d handled %v, want %v", i, step.botID, handled, step.handled)
+		}
+	}
+}
+
+func TestDedupEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		bots      []int64
+		singleBot bool
+		leaders   map[int64]int64
+		steps     []dedupStep
+	}{
+		{
+			name: "received again",
+			bots: []int64{10},
+			steps: []dedupStep{
+				{10, groupMsg(10, 1, "hi"), true},
+				{10, groupMsg(10, 1, "hi"), false},
+				{10, groupMsg(10, 2, "hi"), true},
+			},
+		},
+		{
+			name: "every bot without single-bot",
+			bots: []int64{10, 11},
+			steps: []dedupStep{
+				{10, groupMsg(10, 1, "hi"), true},
+				{11, groupMsg(11, 7, "hi"), true},
+			},
+		},
+		{
+			// 11 gets the first message before 10 is seen, and 10 becoming
+			// the leader does not handle it again
+			name:      "leader seen late",
+			bots:      []int64{10, 11},
+			singleBot: true,
+			steps: []dedupStep{
+				{11, groupMsg(11, 7, "first"), true},
+				{10, groupMsg(10, 1, "first"), false},
+				{11, groupMsg(11, 8, "second"), false},
+				{10, groupMsg(10, 2, "second"), true},
+			},
+		},
+		{
+			name:      "pinned leader",
+			bots:      []int64{10, 11},
+			singleBot: true,
+			leaders:   map[int64]int64{100: 11},
+			steps: []dedupStep{
+				{10, groupMsg(10, 1, "first"), true},
+				{11, groupMsg(11, 7, "first"), false},
+				{10, groupMsg(10, 2, "second"), false},
+				{11, groupMsg(11, 8, "second"), true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useBots(t, tt.bots...)
+			useDedup(t, tt.singleBot, tt.leaders)
+			runDedupSteps(t, tt.steps)
+		})
+	}
+}
+
+func TestGroupLeader(t *testing.T) {
+	useBots(t, 10, 11, 12)
+	useDedup(t, true, map[int64]int64{200: 12, 300: 13})
+	seeGroup(100, 12)
+	seeGroup(100, 11)
+	seeGroup(200, 11)
+	seeGroup(200, 12)
+	seeGroup(300, 11)
+	tests := []struct {
+		groupID int64
+		want    int64
+	}{
+		// the first of the config, whatever the order seen
+		{100, 11},
+		{200, 12},
+		// the pinned leader is not in the group
+		{300, 11},
+		{400, 0},
+	}
+	for _, tt := range tests {
+		if got := GroupLeader(tt.groupID); got != tt.want {
+			t.Errorf("GroupLeader(%d) = %d, want %d", tt.groupID, got, tt.want)
+		}
+	}
+}
@@ -5,14 +5,16 @@ import (
 )
 
 func main() {
-	luxtbot.MakeBeforeApiOutHook(func(apiPost *luxtbot.ApiPost, bInfo luxtbot.BotInfo) error {
-		if apiPost.Action == luxtbot.GroupMsgAction {
-			var groupMsg = (apiPost.Params).(luxtbot.GroupMsg)
+	luxtbot.UseApiOut("example.redirect", luxtbot.PriorityFirst, func(c *luxtbot.ApiOutCtx, next func() error) error {
+		if groupMsg, ok := c.Api.Params.(*luxtbot.GroupMsg); ok {
 			groupMsg.GroupID = 123
 		}
-		return nil
-	}).AddToHookChain()
+		return next()
+	})
 	luxtbot.InitDefaultPluginManager(0)
+	luxtbot.InitDefaultBotManager(1)
+	luxtbot.InitDefaultPermManager(2)
+	luxtbot.InitDefaultBlacklistManager(3)
 	luxtbot.Init("config-file-path.yml")
 	luxtbot.Start()
 	select {}
@@ -0,0 +1,178 @@
+package luxtbot
+
+import (
+	"context"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// PluginStats are the counters of the unit invocations of a plugin.
+type PluginStats struct {
+	Runs     uint64
+	Panics   uint64
+	Timeouts uint64
+}
+
+// pluginStats is allocated apart from Plugin to keep the 64-bit counters aligned.
+type pluginStats struct {
+	runs     uint64
+	panics   uint64
+	timeouts uint64
+}
+
+// globalWorkers limits the units running at the same time of all plugins,
+// nil means no limit.
+var globalWorkers chan struct{}
+
+// initWorkers makes the global worker pool by max-workers of the config.
+func initWorkers() {
+	globalWorkers = nil
+	if Conf.MaxWorkers > 0 {
+		globalWorkers = make(chan struct{}, Conf.MaxWorkers)
+	}
+}
+
+// SetMaxWorkers limits the units of the plugin running at the same time,
+// the events over the limit wait for a free worker. 0 means no limit.
+func (p *Plugin) SetMaxWorkers(n int) *Plugin {
+	p.workers = nil
+	if n > 0 {
+		p.workers = make(chan struct{}, n)
+	}
+	return p
+}
+
+// Stats returns the counters of the plugin.
+func (p *Plugin) Stats() PluginStats {
+	if p.stats == nil {
+		return PluginStats{}
+	}
+	return PluginStats{
+		Runs:     atomic.LoadUint64(&p.stats.runs),
+		Panics:   atomic.LoadUint64(&p.stats.panics),
+		Timeouts: atomic.LoadUint64(&p.stats.timeouts),
+	}
+}
+
+// GetPluginStats returns the counters of all plugins by plugin ID.
+func GetPluginStats() map[int]PluginStats {
+	stats := make(map[int]PluginStats, len(PluginList))
+	for _, plg := range PluginList {
+		stats[plg.ID] = plg.Stats()
+	}
+	return stats
+}
+
+// Context returns the context of the unit processing the event,
+// it is done when the timeout of the unit is reached.
+func (e *Event) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// unitTimeout returns the timeout of a unit, unit-timeout of the config if not set.
+func unitTimeout(timeout time.Duration) time.Duration {
+	if timeout != 0 {
+		return timeout
+	}
+	return time.Duration(CurConf().UnitTimeout) * time.Second
+}
+
+// ErrUnitTimeout is passed to the UnitErrorHooks when a unit runs over its timeout.
+const ErrUnitTimeout Error = "err.unit-timeout"
+
+// UnitPanic is passed to the UnitErrorHooks when a unit panics.
+type UnitPanic struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (up *UnitPanic) Error() string {
+	return T("exec.panic", up.Value)
+}
+
+// runUnit runs a unit in a new goroutine within the worker pools,
+// a panic is recovered and logged, and running over the timeout is logged.
+// The unit is not stopped by the timeout, it should watch the context.
+// The workers are taken in the goroutine, so that a full pool never
+// blocks the dispatcher, nor the events of the other plugins and bots.
+func runUnit(plg *Plugin, unit string, timeout time.Duration, e *Event, bCtx *BotContext, args []string, h func(c *Ctx)) {
+	plgWorkers, workers := plg.workers, globalWorkers
+	go func() {
+		if plgWorkers != nil {
+			plgWorkers <- struct{}{}
+		}
+		if workers != nil {
+			workers <- struct{}{}
+		}
+		defer func() {
+			if workers != nil {
+				<-workers
+			}
+			if plgWorkers != nil {
+				<-plgWorkers
+			}
+		}()
+		ctx := context.Background()
+		timeout = unitTimeout(timeout)
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		e.ctx = ctx
+		c := newCtx(ctx, e, bCtx, plg, unit, args)
+		var start time.Time
+		defer func() {
+			if err := recover(); err != nil {
+				if plg.stats != nil {
+					atomic.AddUint64(&plg.stats.panics, 1)
+				}
+				up := &UnitPanic{Value: err, Stack: debug.Stack()}
+				c.Log.Errorf("%v\n%s", up, up.Stack)
+				runUnitErrorHooks(c, up)
+			}
+			// the after hooks run only if the unit is started
+			if !start.IsZero() {
+				runAfterUnitHooks(c, time.Since(start))
+			}
+		}()
+		if err := runBeforeUnitHooks(c); err != nil {
+			c.Log.Debugln(T("exec.skipped", err))
+			return
+		}
+		if plg.stats != nil {
+			atomic.AddUint64(&plg.stats.runs, 1)
+		}
+		if timeout > 0 {
+			timer := time.AfterFunc(timeout, func() {
+				if plg.stats != nil {
+					atomic.AddUint64(&plg.stats.timeouts, 1)
+				}
+				c.Log.Warnln(T("exec.timeout", timeout))
+				runUnitErrorHooks(c, ErrUnitTimeout)
+			})
+			defer timer.Stop()
+		}
+		start = time.Now()
+		h(c)
+	}()
+}
+
+// runBacken runs the start func of a backen unit with panic recovery.
+func runBacken(bp BackenUnit, bInfos []BotInfo) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				if bp.Plg.stats != nil {
+					atomic.AddUint64(&bp.Plg.stats.panics, 1)
+				}
+				LBLogger.WithField("PluginID", bp.Plg.ID).Errorf("%v\n%s", T("exec.panic", err), debug.Stack())
+			}
+		}()
+		bp.Start(bInfos)
+	}()
+}
@@ -0,0 +1,166 @@
+package luxtbot
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultFailoverCooldown = 600
+
+	failoverHookName = "luxtbot.failover"
+)
+
+// ErrNoBotAvailable is returned when no online bot could send to the group.
+const ErrNoBotAvailable Error = "err.no-bot"
+
+type groupSendConf struct {
+	roundRobin bool
+	priority   []int64
+	timeout    time.Duration
+}
+
+// GroupSendOption changes how SendGroupMsg chooses the bot.
+type GroupSendOption func(gs *groupSendConf)
+
+// WithRoundRobin takes turns among the bots in the group,
+// instead of the first bot by priority.
+func WithRoundRobin() GroupSendOption {
+	return func(gs *groupSendConf) {
+		gs.roundRobin = true
+	}
+}
+
+// WithBotPriority tries the bots in this order first,
+// and then the rest by the order of the config.
+func WithBotPriority(botIDs ...int64) GroupSendOption {
+	return func(gs *groupSendConf) {
+		gs.priority = botIDs
+	}
+}
+
+// WithSendTimeout sets the timeout of every bot tried, DefaultApiTimeout by default.
+func WithSendTimeout(timeout time.Duration) GroupSendOption {
+	return func(gs *groupSendConf) {
+		gs.timeout = timeout
+	}
+}
+
+var (
+	// bot id -> the time until which the bot is not chosen after a failure
+	botCooldowns = make(map[int64]time.Time)
+	// group id -> the count of the messages sent by round robin
+	groupTurns   = make(map[int64]int)
+	failoverLock sync.Mutex
+)
+
+func init() {
+	OnBotState(failoverHookName, LegacyHookPriority, func(bInfo BotInfo, state BotState) {
+		if state == BotOnline {
+			failoverLock.Lock()
+			delete(botCooldowns, bInfo.BotID)
+			failoverLock.Unlock()
+		}
+	})
+}
+
+// SendGroupMsg sends the message to the group by one of the online bots in
+// it, and returns the bot and the message_id. A bot failing to send, which
+// is offline or risk controlled, is skipped for failover-cooldown seconds,
+// and the next bot is tried. If no bot is known to be in the group, all of
+// the online bots are tried.
+func SendGroupMsg(groupID int64, msg MsgBuilder, opts ...GroupSendOption) (int64, int, error) {
+	var gs groupSendConf
+	for _, opt := range opts {
+		opt(&gs)
+	}
+	data, err := msg.GetMsg()
+	if err != nil {
+		return 0, 0, err
+	}
+	candidates := sendCandidates(groupID, &gs)
+	if len(candidates) == 0 {
+		return 0, 0, fmt.Errorf("%w: %d", ErrNoBotAvailable, groupID)
+	}
+	api := makeApi(GroupMsgAction, &GroupMsg{GroupID: groupID, Message: data})
+	var lastErr error
+	for _, botID := range candidates {
+		resp, err := api.DoWithResp(botID, gs.timeout)
+		if err == nil {
+			return botID, resp.Data.MessageID, nil
+		}
+		// not a failure of the bot, the other bots would be the same
+		if errors.Is(err, ErrMsgFiltered) {
+			return 0, 0, err
+		}
+		LBLogger.WithField("BotID", botID).WithField("GroupID", groupID).Warnln(T("failover.failed", err))
+		coolDown(botID)
+		lastErr = err
+	}
+	return 0, 0, fmt.Errorf("%w: %v", ErrNoBotAvailable, lastErr)
+}
+
+// sendCandidates orders the online bots not cooling down to try.
+func sendCandidates(groupID int64, gs *groupSendConf) []int64 {
+	botIDs := GroupBots(groupID)
+	if len(botIDs) == 0 {
+		botIDs = onlineBots()
+	}
+	now := time.Now()
+	failoverLock.Lock()
+	defer failoverLock.Unlock()
+	var ready, cooling []int64
+	for _, botID := range orderByPriority(botIDs, gs.priority) {
+		if now.Before(botCooldowns[botID]) {
+			cooling = append(cooling, botID)
+		} else {
+			ready = append(ready, botID)
+		}
+	}
+	if gs.roundRobin && len(ready) > 1 {
+		turn := groupTurns[groupID] % len(ready)
+		groupTurns[groupID]++
+		ready = append(ready[turn:], ready[:turn]...)
+	}
+	// the bots cooling down are the last resort
+	return append(ready, cooling...)
+}
+
+func orderByPriority(botIDs, priority []int64) []int64 {
+	if len(priority) == 0 {
+		return botIDs
+	}
+	ordered := make([]int64, 0, len(botIDs))
+	for _, p := range priority {
+		if containsID(botIDs, p) {
+			ordered = append(ordered, p)
+		}
+	}
+	for _, botID := range botIDs {
+		if !containsID(priority, botID) {
+			ordered = append(ordered, botID)
+		}
+	}
+	return ordered
+}
+
+func coolDown(botID int64) {
+	failoverLock.Lock()
+	defer failoverLock.Unlock()
+	botCooldowns[botID] = time.Now().Add(time.Duration(CurConf().FailoverCooldown) * time.Second)
+}
+
+// onlineBots returns the online bots by the order of the config.
+func onlineBots() []int64 {
+	botsLock.RLock()
+	defer botsLock.RUnlock()
+	var botIDs []int64
+	for _, bCtx := range bots {
+		if botReady(bCtx) {
+			botIDs = append(botIDs, bCtx.Info().BotID)
+		}
+	}
+	return botIDs
+}
@@ -0,0 +1,396 @@
+package luxtbot
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lutil "github.com/ABiao0306/luxtbot/util"
+)
+
+const (
+	FilterPolicyMask    = "mask"
+	FilterPolicyReplace = "replace"
+	FilterPolicyDrop    = "drop"
+
+	filterHookName = "luxtbot.filter"
+)
+
+// ErrMsgFiltered is returned by the filter middleware for messages dropped.
+const ErrMsgFiltered Error = "err.msg-filtered"
+
+// FilterConf is the filter of the messages sent by the bots, it checks the
+// text of the messages, the merged forward messages included, against the
+// words and the links against the domains.
+type FilterConf struct {
+	Enable bool     `yaml:"enable"`
+	Words  []string `yaml:"words,omitempty"`
+	// files of one word a line
+	WordFiles  []string `yaml:"word-files,omitempty"`
+	IgnoreCase bool     `yaml:"ignore-case"`
+	// mask (default), replace or drop
+	Policy string `yaml:"policy,omitempty"`
+	// the char masking every char of a word, * by default
+	Mask string `yaml:"mask,omitempty"`
+	// the text replacing a word for the replace policy
+	Replacement string `yaml:"replacement,omitempty"`
+	// only the links to these domains and their subdomains are allowed if not empty
+	URLAllow []string `yaml:"url-allow,omitempty"`
+	URLDeny  []string `yaml:"url-deny,omitempty"`
+	// a JSON line is appended to this file for every message filtered
+	AuditFile string `yaml:"audit-file,omitempty"`
+}
+
+// outFilter is the filter made from FilterConf.
+type outFilter struct {
+	conf    FilterConf
+	matcher *lutil.Matcher
+	mask    rune
+}
+
+var (
+	urlPattern = regexp.MustCompile(`(?i)(?:https?://|www\.)[^\s\x{3000}-\x{303f}\x{ff01}-\x{ff0f}<>"'\[\]]+`)
+	auditLock  sync.Mutex
+)
+
+// text of the segments filtered, the structured ones could not be edited,
+// and a message with a hit in them is dropped whatever the policy is.
+var (
+	filterSegKeys = map[string][]string{
+		TextMsgSeg:  {"text"},
+		TTSMsgSeg:   {"text"},
+		ShareMsgSeg: {"title", "content"},
+		MusicMsgSeg: {"title", "content"},
+		XmlMsgSeg:   {"data"},
+		JsonMsgSeg:  {"data"},
+	}
+	filterDropSegs = map[string]bool{XmlMsgSeg: true, JsonMsgSeg: true}
+)
+
+func init() {
+	UseApiOut(filterHookName, PriorityLast, func(c *ApiOutCtx, next func() error) error {
+		if err := filterApi(CurConf().filter, c.Api, c.BotInfo()); err != nil {
+			return err
+		}
+		return next()
+	})
+}
+
+// newOutFilter makes the filter, nil if it is not enabled.
+func newOutFilter(conf FilterConf) (*outFilter, error) {
+	if !conf.Enable {
+		return nil, nil
+	}
+	switch conf.Policy {
+	case "":
+		conf.Policy = FilterPolicyMask
+	case FilterPolicyMask, FilterPolicyReplace, FilterPolicyDrop:
+	default:
+		return nil, errors.New(T("conf.filter-policy", conf.Policy))
+	}
+	words := append([]string(nil), conf.Words...)
+	for _, path := range conf.WordFiles {
+		fileWords, err := readWordFile(path)
+		if err != nil {
+			return nil, errors.New(T("conf.filter-file", path, err))
+		}
+		words = append(words, fileWords...)
+	}
+	of := &outFilter{conf: conf, matcher: lutil.NewMatcher(words, conf.IgnoreCase), mask: '*'}
+	if conf.Mask != "" {
+		of.mask = []rune(conf.Mask)[0]
+	}
+	return of, nil
+}
+
+func readWordFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" && !strings.HasPrefix(word, "#") {
+			words = append(words, word)
+		}
+	}
+	return words, scanner.Err()
+}
+
+// FilterText filters the text by the filter of the config, hits are the
+// words and the links found, and drop means the message should be dropped.
+func FilterText(text string) (filtered string, hits []string, drop bool) {
+	of := CurConf().filter
+	if of == nil {
+		return text, nil, false
+	}
+	return of.filterText(text)
+}
+
+func (of *outFilter) filterText(text string) (string, []string, bool) {
+	runes := []rune(text)
+	var (
+		spans []lutil.Match
+		hits  []string
+	)
+	if !of.matcher.Empty() {
+		for _, m := range of.matcher.FindAll(runes) {
+			spans = append(spans, m)
+			hits = append(hits, string(runes[m.Start:m.End]))
+		}
+	}
+	for _, loc := range urlPattern.FindAllStringIndex(text, -1) {
+		link := text[loc[0]:loc[1]]
+		if of.urlAllowed(link) {
+			continue
+		}
+		start := len([]rune(text[:loc[0]]))
+		spans = append(spans, lutil.Match{Start: start, End: start + len([]rune(link))})
+		hits = append(hits, link)
+	}
+	if len(spans) == 0 {
+		return text, nil, false
+	}
+	if of.conf.Policy == FilterPolicyDrop {
+		return text, hits, true
+	}
+	return of.apply(runes, mergeSpans(spans)), hits, false
+}
+
+// apply masks or replaces the spans, which are sorted and not overlapping.
+func (of *outFilter) apply(runes []rune, spans []lutil.Match) string {
+	var sb strings.Builder
+	last := 0
+	for _, span := range spans {
+		sb.WriteString(string(runes[last:span.Start]))
+		if of.conf.Policy == FilterPolicyReplace {
+			replacement := of.conf.Replacement
+			if replacement == "" {
+				replacement = T("filter.replacement")
+			}
+			sb.WriteString(replacement)
+		} else {
+			sb.WriteString(strings.Repeat(string(of.mask), span.End-span.Start))
+		}
+		last = span.End
+	}
+	sb.WriteString(string(runes[last:]))
+	return sb.String()
+}
+
+func mergeSpans(spans []lutil.Match) []lutil.Match {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	merged := spans[:1]
+	for _, span := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if span.Start <= last.End {
+			if span.End > last.End {
+				last.End = span.End
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
+
+func (of *outFilter) urlAllowed(link string) bool {
+	if len(of.conf.URLAllow) == 0 && len(of.conf.URLDeny) == 0 {
+		return true
+	}
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range of.conf.URLDeny {
+		if matchDomain(host, domain) {
+			return false
+		}
+	}
+	if len(of.conf.URLAllow) == 0 {
+		return true
+	}
+	for _, domain := range of.conf.URLAllow {
+		if matchDomain(host, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDomain reports whether host is the domain or a subdomain of it.
+func matchDomain(host, domain string) bool {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// filterApi filters the message of the api of sending messages, the params
+// are normalized by normalizeMsgApi first if the filter is enabled, and the
+// apis of params it could not decode are dropped, as their messages could
+// not be checked.
+func filterApi(of *outFilter, api *ApiPost, bInfo BotInfo) error {
+	if of == nil {
+		return nil
+	}
+	if !normalizeMsgApi(api) {
+		LBLogger.WithField("BotName", bInfo.Name).Warnln(T("filter.bad-params", api.Action))
+		return fmt.Errorf("%w: %v", ErrMsgFiltered, api.Action)
+	}
+	var (
+		hits []string
+		drop bool
+	)
+	switch params := api.Params.(type) {
+	case *GroupMsg:
+		params.Message, hits, drop = of.filterData(params.Message)
+	case *PrivateMsg:
+		params.Message, hits, drop = of.filterData(params.Message)
+	case *ForwardMsg:
+		params.Messages, hits, drop = of.filterMessage(params.Messages)
+	default:
+		return nil
+	}
+	if len(hits) == 0 {
+		return nil
+	}
+	of.audit(api, bInfo, hits, drop)
+	if drop {
+		return fmt.Errorf("%w: %v", ErrMsgFiltered, api.Action)
+	}
+	return nil
+}
+
+// filterData filters the message in the string or the array form.
+func (of *outFilter) filterData(data interface{}) (interface{}, []string, bool) {
+	msg, isString := toMessage(data)
+	if msg == nil {
+		return data, nil, false
+	}
+	filtered, hits, drop := of.filterMessage(msg)
+	if len(hits) == 0 {
+		return data, nil, false
+	}
+	if isString {
+		return filtered.String(), hits, drop
+	}
+	return filtered, hits, drop
+}
+
+// filterMessage filters the text of the segments in filterSegKeys and the
+// contents of the custom nodes, the changed segments are copied so the
+// message given is untouched.
+func (of *outFilter) filterMessage(msg Message) (Message, []string, bool) {
+	var (
+		filtered Message
+		allHits  []string
+	)
+	for i, seg := range msg {
+		keys := filterSegKeys[seg.Type]
+		if seg.Type == NodeMsgSeg {
+			keys = []string{"content"}
+		}
+		var data map[string]string
+		for _, key := range keys {
+			if seg.Data[key] == "" {
+				continue
+			}
+			var (
+				text string
+				hits []string
+				drop bool
+			)
+			if seg.Type == NodeMsgSeg {
+				var content Message
+				content, hits, drop = of.filterMessage(ParseMsgSegs(seg.Data[key]))
+				text = content.String()
+			} else {
+				text, hits, drop = of.filterText(seg.Data[key])
+			}
+			if drop || len(hits) != 0 && filterDropSegs[seg.Type] {
+				return msg, append(allHits, hits...), true
+			}
+			if len(hits) == 0 {
+				continue
+			}
+			allHits = append(allHits, hits...)
+			if data == nil {
+				data = make(map[string]string, len(seg.Data))
+				for k, v := range seg.Data {
+					data[k] = v
+				}
+			}
+			data[key] = text
+		}
+		if data == nil {
+			continue
+		}
+		if filtered == nil {
+			filtered = append(Message(nil), msg...)
+		}
+		filtered[i] = MsgSeg{Type: seg.Type, Data: data}
+	}
+	if filtered == nil {
+		return msg, allHits, false
+	}
+	return filtered, allHits, false
+}
+
+type auditRecord struct {
+	Time    string   `json:"time"`
+	BotID   int64    `json:"bot_id"`
+	Action  string   `json:"action"`
+	GroupID int64    `json:"group_id,omitempty"`
+	UserID  int64    `json:"user_id,omitempty"`
+	Hits    []string `json:"hits"`
+	Dropped bool     `json:"dropped"`
+}
+
+// audit logs the message filtered, and appends it to the audit file if set.
+func (of *outFilter) audit(api *ApiPost, bInfo BotInfo, hits []string, drop bool) {
+	rec := auditRecord{
+		Time:    time.Now().Format(time.RFC3339),
+		BotID:   bInfo.BotID,
+		Action:  api.Action,
+		Hits:    hits,
+		Dropped: drop,
+	}
+	switch params := api.Params.(type) {
+	case *GroupMsg:
+		rec.GroupID = params.GroupID
+	case *PrivateMsg:
+		rec.UserID, rec.GroupID = params.UserID, params.GroupID
+	case *ForwardMsg:
+		rec.UserID, rec.GroupID = params.UserID, params.GroupID
+	}
+	LBLogger.WithField("BotName", bInfo.Name).WithField("GroupID", rec.GroupID).WithField("UserID", rec.UserID).
+		Infoln(T("filter.audit", strings.Join(hits, ", "), drop))
+	if of.conf.AuditFile == "" {
+		return
+	}
+	line, _ := json.Marshal(rec)
+	auditLock.Lock()
+	defer auditLock.Unlock()
+	f, err := os.OpenFile(of.conf.AuditFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		LBLogger.Warnln(T("filter.audit-fail", err))
+		return
+	}
+	defer f.Close()
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		LBLogger.Warnln(T("filter.audit-fail", err))
+	}
+}
@@ -0,0 +1,213 @@
+package luxtbot
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	lutil "github.com/ABiao0306/luxtbot/util"
+)
+
+// useConf makes conf the running config until the test ends.
+func useConf(t *testing.T, conf Config) {
+	old := *CurConf()
+	setCurConf(conf)
+	t.Cleanup(func() { setCurConf(old) })
+}
+
+func mustFilter(t *testing.T, conf FilterConf) *outFilter {
+	conf.Enable = true
+	of, err := newOutFilter(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return of
+}
+
+func TestFilterText(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     FilterConf
+		text     string
+		want     string
+		wantHits []string
+		wantDrop bool
+	}{
+		{"no hit", FilterConf{Words: []string{"bad"}}, "good", "good", nil, false},
+		{"mask", FilterConf{Words: []string{"bad"}}, "a bad b", "a *** b", []string{"bad"}, false},
+		{"custom mask", FilterConf{Words: []string{"坏词"}, Mask: "#"}, "有坏词", "有##", []string{"坏词"}, false},
+		{"replace", FilterConf{Words: []string{"bad"}, Policy: FilterPolicyReplace, Replacement: "[x]"}, "bad!", "[x]!", []string{"bad"}, false},
+		{"drop", FilterConf{Words: []string{"bad"}, Policy: FilterPolicyDrop}, "so bad", "so bad", []string{"bad"}, true},
+		{"ignore case", FilterConf{Words: []string{"Bad"}, IgnoreCase: true}, "BAD", "***", []string{"BAD"}, false},
+		{"overlapping words", FilterConf{Words: []string{"abc", "bcd"}}, "xabcdx", "x****x", []string{"abc", "bcd"}, false},
+		{"nested words", FilterConf{Words: []string{"abcd", "bc"}}, "abcd", "****", []string{"abcd", "bc"}, false},
+		{"url denied", FilterConf{URLDeny: []string{"evil.com"}}, "see https://a.evil.com/x ok", "see ******************** ok", []string{"https://a.evil.com/x"}, false},
+		{"url allowed", FilterConf{URLAllow: []string{"good.com"}}, "see www.good.com", "see www.good.com", nil, false},
+		{"url not allowed", FilterConf{URLAllow: []string{"good.com"}}, "www.bad.cn", "**********", []string{"www.bad.cn"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, hits, drop := mustFilter(t, tt.conf).filterText(tt.text)
+			if got != tt.want || drop != tt.wantDrop {
+				t.Errorf("filterText(%q) = %q, %v, want %q, %v", tt.text, got, drop, tt.want, tt.wantDrop)
+			}
+			if len(hits) != len(tt.wantHits) {
+				t.Fatalf("filterText(%q) hits %q, want %q", tt.text, hits, tt.wantHits)
+			}
+			for _, hit := range tt.wantHits {
+				found := false
+				for _, h := range hits {
+					found = found || h == hit
+				}
+				if !found {
+					t.Errorf("filterText(%q) hits %q, want %q", tt.text, hits, tt.wantHits)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeSpans(t *testing.T) {
+	tests := []struct {
+		spans []lutil.Match
+		want  []lutil.Match
+	}{
+		{[]lutil.Match{{Start: 0, End: 2}}, []lutil.Match{{Start: 0, End: 2}}},
+		{[]lutil.Match{{Start: 4, End: 6}, {Start: 0, End: 2}}, []lutil.Match{{Start: 0, End: 2}, {Start: 4, End: 6}}},
+		{[]lutil.Match{{Start: 0, End: 3}, {Start: 2, End: 5}}, []lutil.Match{{Start: 0, End: 5}}},
+		{[]lutil.Match{{Start: 0, End: 5}, {Start: 1, End: 2}}, []lutil.Match{{Start: 0, End: 5}}},
+		// touching spans are merged too
+		{[]lutil.Match{{Start: 0, End: 2}, {Start: 2, End: 4}}, []lutil.Match{{Start: 0, End: 4}}},
+	}
+	for _, tt := range tests {
+		if got := mergeSpans(append([]lutil.Match(nil), tt.spans...)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeSpans(%v) = %v, want %v", tt.spans, got, tt.want)
+		}
+	}
+}
+
+func TestFilterApi(t *testing.T) {
+	of := mustFilter(t, FilterConf{Words: []string{"bad"}})
+	tests := []struct {
+		name   string
+		of     *outFilter
+		api    ApiPost
+		want   ApiPost
+		errors bool
+	}{
+		{
+			name: "disabled keeps send_msg",
+			api:  ApiPost{Action: SendMsgAction, Params: map[string]interface{}{"group_id": 1, "message": "bad"}},
+			want: ApiPost{Action: SendMsgAction, Params: map[string]interface{}{"group_id": 1, "message": "bad"}},
+		},
+		{
+			name: "send_msg",
+			of:   of,
+			api:  ApiPost{Action: SendMsgAction, Params: map[string]interface{}{"message_type": "group", "group_id": 1, "message": "bad"}},
+			want: ApiPost{Action: GroupMsgAction, Params: &GroupMsg{GroupID: 1, Message: "***"}},
+		},
+		{
+			name: "value params",
+			of:   of,
+			api:  ApiPost{Action: PrivateMsgAction, Params: PrivateMsg{UserID: 2, Message: Message{textSeg("bad")}}},
+			want: ApiPost{Action: PrivateMsgAction, Params: &PrivateMsg{UserID: 2, Message: Message{textSeg("***")}}},
+		},
+		{
+			name: "numeric data",
+			of:   of,
+			api: ApiPost{Action: GroupMsgAction, Params: map[string]interface{}{"group_id": 1, "message": []interface{}{
+				map[string]interface{}{"type": "at", "data": map[string]interface{}{"qq": 123}},
+				map[string]interface{}{"type": "text", "data": map[string]interface{}{"text": " bad"}},
+			}}},
+			want: ApiPost{Action: GroupMsgAction, Params: &GroupMsg{GroupID: 1, Message: Message{
+				{Type: AtMsgSeg, Data: map[string]string{"qq": "123"}},
+				textSeg(" ***"),
+			}}},
+		},
+		{
+			name: "node content array",
+			of:   of,
+			api: ApiPost{Action: GroupForwardMsgAction, Params: map[string]interface{}{"group_id": 1, "messages": []interface{}{
+				map[string]interface{}{"type": "node", "data": map[string]interface{}{
+					"name": "a", "uin": 10, "content": []interface{}{map[string]interface{}{"type": "text", "data": map[string]interface{}{"text": "bad"}}},
+				}},
+			}}},
+			want: ApiPost{Action: GroupForwardMsgAction, Params: &ForwardMsg{GroupID: 1, Messages: Message{
+				{Type: NodeMsgSeg, Data: map[string]string{"name": "a", "uin": "10", "content": "***"}},
+			}}},
+		},
+		{
+			name:   "json dropped",
+			of:     of,
+			api:    ApiPost{Action: GroupMsgAction, Params: &GroupMsg{GroupID: 1, Message: Message{{Type: JsonMsgSeg, Data: map[string]string{"data": `{"a":"bad"}`}}}}},
+			errors: true,
+		},
+		{
+			name:   "undecodable",
+			of:     of,
+			api:    ApiPost{Action: GroupMsgAction, Params: map[string]interface{}{"group_id": 1, "message": 42}},
+			errors: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := tt.api
+			err := filterApi(tt.of, &api, BotInfo{})
+			if tt.errors {
+				if !errors.Is(err, ErrMsgFiltered) {
+					t.Errorf("filterApi() = %v, want ErrMsgFiltered", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(api, tt.want) {
+				t.Errorf("filterApi() = %#v, want %#v", api, tt.want)
+			}
+		})
+	}
+}
+
+// a word crossing the boundary of the parts is filtered, as the message is
+// split after the hooks
+func TestFilterBeforeSplit(t *testing.T) {
+	tests := []struct {
+		policy string
+		drop   bool
+	}{
+		{FilterPolicyMask, false},
+		{FilterPolicyDrop, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			conf := *CurConf()
+			conf.Filter = FilterConf{Enable: true, Words: []string{"badword"}, Policy: tt.policy}
+			var err error
+			if conf.filter, err = newOutFilter(conf.Filter); err != nil {
+				t.Fatal(err)
+			}
+			useConf(t, conf)
+			bCtx := newBotCtx(&BotInfo{BotID: 1, Name: "1", MaxMsgLen: 6})
+			api := makeApi(GroupMsgAction, &GroupMsg{GroupID: 1, Message: "aaaabadwordcc"})
+			parts := passApi(bCtx, api)
+			if tt.drop {
+				if parts != nil {
+					t.Errorf("passApi() = %v, want nothing", parts)
+				}
+				return
+			}
+			if len(parts) < 2 {
+				t.Fatalf("passApi() = %v, want the parts", parts)
+			}
+			var sb strings.Builder
+			for _, part := range parts {
+				sb.WriteString(part.Params.(*GroupMsg).Message.(string))
+			}
+			if got := sb.String(); got != "aaaa*******cc" {
+				t.Errorf("passApi() parts joined = %q", got)
+			}
+		})
+	}
+}
@@ -3,13 +3,14 @@ module github.com/ABiao0306/luxtbot
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/gorilla/websocket v1.4.2
 	github.com/jonboulle/clockwork v0.2.2 // indirect
 	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible
 	github.com/lestrrat-go/strftime v1.0.4 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/rs/xid v1.3.0 // indirect
+	github.com/rs/xid v1.3.0
 	github.com/sirupsen/logrus v1.8.1
-	golang.org/x/sys v0.0.0-20210423082822-04245dca01da // indirect
+	golang.org/x/image v0.5.0
 	gopkg.in/yaml.v2 v2.4.0
 )
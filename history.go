@@ -0,0 +1,368 @@
+package luxtbot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	lutil "github.com/ABiao0306/luxtbot/util"
+)
+
+const (
+	DefaultHistorySize          = 200
+	DefaultHistoryFlushInterval = 60
+
+	DeleteMsgAction = "delete_msg"
+
+	historyDataName = "history"
+	historyHookName = "luxtbot.history"
+	// the prefix of the echoes given by the history, which have no callbacks
+	historyEchoPrefix = "history-"
+)
+
+// HistoryConf keeps the recent messages received and sent by the bots,
+// size messages for every chat of every bot.
+type HistoryConf struct {
+	Enable bool `yaml:"enable"`
+	// 200 by default
+	Size int `yaml:"size"`
+	// saves the history to data-dir/history.json every flush-interval seconds
+	Persist       bool `yaml:"persist"`
+	FlushInterval int  `yaml:"flush-interval"`
+}
+
+// HistoryMsg is a message kept in the history. MessageID is 0 for the
+// messages sent by the async actions.
+type HistoryMsg struct {
+	MessageID int     `json:"message_id"`
+	BotID     int64   `json:"bot_id"`
+	GroupID   int64   `json:"group_id,omitempty"`
+	UserID    int64   `json:"user_id"`
+	Nickname  string  `json:"nickname,omitempty"`
+	Message   Message `json:"message"`
+	Time      int64   `json:"time"`
+	// sent by the bot
+	Outgoing bool `json:"outgoing,omitempty"`
+}
+
+// historyRing is a ring of the messages of a chat.
+type historyRing struct {
+	msgs  []HistoryMsg
+	start int
+}
+
+type historyKey struct {
+	botID     int64
+	messageID int
+}
+
+var (
+	// chat key -> messages
+	histories = make(map[string]*historyRing)
+	// bot id and message id -> chat key
+	historyIndex = make(map[historyKey]string)
+	historyDirty bool
+	historyLock  sync.RWMutex
+
+	// echo -> the message sent waiting for the message_id
+	pendingSent = lutil.NewLRU(1024, DefaultApiTimeout*2)
+)
+
+func init() {
+	UseEventIn(historyHookName, PriorityLast, func(c *EventInCtx, next func() error) error {
+		e := c.Event
+		if CurConf().History.Enable && e.PostType == MessageEvent {
+			addHistory(HistoryMsg{
+				MessageID: e.MessageID,
+				BotID:     c.Bot.Info().BotID,
+				GroupID:   groupOf(e),
+				UserID:    e.UserID,
+				Nickname:  e.Sender.Nickname,
+				Message:   e.Message,
+				Time:      int64(e.Time),
+			}, privatePeer(e))
+		}
+		return next()
+	})
+	OnApiResp(historyHookName, LegacyHookPriority, func(resp *ApiResp, bInfo BotInfo) {
+		if resp.Echo == "" {
+			return
+		}
+		v, ok := pendingSent.Get(resp.Echo)
+		if !ok {
+			return
+		}
+		pendingSent.Remove(resp.Echo)
+		if resp.Err() != nil {
+			return
+		}
+		pending := v.(pendingMsg)
+		pending.msg.MessageID = resp.Data.MessageID
+		addHistory(pending.msg, pending.peer)
+	})
+}
+
+type pendingMsg struct {
+	msg  HistoryMsg
+	peer int64
+}
+
+// groupOf returns the group of a group message, 0 for the private ones
+// including the temp session.
+func groupOf(e *Event) int64 {
+	if e.MessageType == MsgTypeGroup {
+		return e.GroupID
+	}
+	return 0
+}
+
+func privatePeer(e *Event) int64 {
+	if e.MessageType == MsgTypeGroup {
+		return 0
+	}
+	return e.UserID
+}
+
+// chatKey is the key of a group chat or a private chat with the peer.
+func chatKey(botID, groupID, peer int64) string {
+	if groupID != 0 {
+		return fmt.Sprintf("%d:g%d", botID, groupID)
+	}
+	return fmt.Sprintf("%d:p%d", botID, peer)
+}
+
+// recordSent keeps the message sent when the response comes, an echo is
+// given to the api without one so that the message_id is known. It is
+// called for every part of the message after the api-out hooks, so that
+// what is kept is what is sent.
+func recordSent(api *ApiPost, bInfo BotInfo) {
+	// only the echo of the api sent is changed
+	normalized := *api
+	if !normalizeMsgApi(&normalized) {
+		return
+	}
+	msg := HistoryMsg{
+		BotID:    bInfo.BotID,
+		UserID:   bInfo.BotID,
+		Nickname: bInfo.Name,
+		Time:     time.Now().Unix(),
+		Outgoing: true,
+	}
+	var peer int64
+	switch params := normalized.Params.(type) {
+	case *GroupMsg:
+		msg.GroupID = params.GroupID
+		msg.Message, _ = toMessage(params.Message)
+	case *PrivateMsg:
+		peer = params.UserID
+		msg.Message, _ = toMessage(params.Message)
+	case *ForwardMsg:
+		msg.GroupID = params.GroupID
+		peer = params.UserID
+		msg.Message = params.Messages
+	default:
+		return
+	}
+	if api.Echo == "" {
+		api.Echo = historyEchoPrefix + lutil.GetEchoStr()
+	}
+	pendingSent.Add(api.Echo, pendingMsg{msg: msg, peer: peer})
+}
+
+func addHistory(msg HistoryMsg, peer int64) {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	pushHistory(chatKey(msg.BotID, msg.GroupID, peer), msg)
+	historyDirty = true
+}
+
+// pushHistory adds the message to the chat, historyLock must be held.
+func pushHistory(key string, msg HistoryMsg) {
+	size := CurConf().History.Size
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	ring := histories[key]
+	if ring == nil {
+		ring = &historyRing{}
+		histories[key] = ring
+	}
+	for _, evicted := range ring.push(msg, size) {
+		if historyIndex[historyKey{evicted.BotID, evicted.MessageID}] == key {
+			delete(historyIndex, historyKey{evicted.BotID, evicted.MessageID})
+		}
+	}
+	if msg.MessageID != 0 {
+		historyIndex[historyKey{msg.BotID, msg.MessageID}] = key
+	}
+}
+
+// push adds the message and returns the oldest ones evicted.
+// A ring of another size is rebuilt first.
+func (r *historyRing) push(msg HistoryMsg, size int) []HistoryMsg {
+	var evicted []HistoryMsg
+	if cap(r.msgs) != size {
+		msgs := r.list()
+		if len(msgs) > size {
+			evicted = msgs[:len(msgs)-size]
+			msgs = msgs[len(msgs)-size:]
+		}
+		r.msgs, r.start = append(make([]HistoryMsg, 0, size), msgs...), 0
+	}
+	if len(r.msgs) < size {
+		r.msgs = append(r.msgs, msg)
+		return evicted
+	}
+	evicted = append(evicted, r.msgs[r.start])
+	r.msgs[r.start] = msg
+	r.start = (r.start + 1) % size
+	return evicted
+}
+
+// list returns the messages from the oldest.
+func (r *historyRing) list() []HistoryMsg {
+	msgs := make([]HistoryMsg, 0, len(r.msgs))
+	msgs = append(msgs, r.msgs[r.start:]...)
+	return append(msgs, r.msgs[:r.start]...)
+}
+
+// GetHistoryMsg returns the message of the bot by message_id.
+func GetHistoryMsg(botID int64, messageID int) (HistoryMsg, bool) {
+	historyLock.RLock()
+	defer historyLock.RUnlock()
+	key, ok := historyIndex[historyKey{botID, messageID}]
+	if !ok {
+		return HistoryMsg{}, false
+	}
+	for _, msg := range histories[key].msgs {
+		if msg.MessageID == messageID {
+			return msg, true
+		}
+	}
+	return HistoryMsg{}, false
+}
+
+// RecentMsgs returns the last n messages of the group chat, or the private
+// chat with the user if groupID is 0, from the oldest.
+func RecentMsgs(botID, groupID, userID int64, n int) []HistoryMsg {
+	historyLock.RLock()
+	defer historyLock.RUnlock()
+	ring := histories[chatKey(botID, groupID, userID)]
+	if ring == nil {
+		return nil
+	}
+	msgs := ring.list()
+	if n > 0 && len(msgs) > n {
+		msgs = msgs[len(msgs)-n:]
+	}
+	return msgs
+}
+
+// BotLastMsgs returns the last n messages sent by the bot to the chat, from the latest.
+func BotLastMsgs(botID, groupID, userID int64, n int) []HistoryMsg {
+	msgs := RecentMsgs(botID, groupID, userID, 0)
+	var sent []HistoryMsg
+	for i := len(msgs) - 1; i >= 0 && len(sent) < n; i-- {
+		if msgs[i].Outgoing && msgs[i].MessageID != 0 {
+			sent = append(sent, msgs[i])
+		}
+	}
+	return sent
+}
+
+// RecallMsg recalls the message by the bot.
+func RecallMsg(botID int64, messageID int) error {
+	api := makeApi(DeleteMsgAction, map[string]interface{}{"message_id": messageID})
+	_, err := api.DoWithResp(botID, 0)
+	return err
+}
+
+// RecallLastMsgs recalls the last n messages sent by the bot to the chat,
+// and returns how many are recalled.
+func RecallLastMsgs(botID, groupID, userID int64, n int) (int, error) {
+	recalled := 0
+	for _, msg := range BotLastMsgs(botID, groupID, userID, n) {
+		if err := RecallMsg(botID, msg.MessageID); err != nil {
+			return recalled, err
+		}
+		recalled++
+	}
+	return recalled, nil
+}
+
+// HistoryText formats the last n messages of the chat, a line for every
+// message as "nickname: text", for summarizing the conversation.
+func HistoryText(botID, groupID, userID int64, n int) string {
+	var sb strings.Builder
+	for _, msg := range RecentMsgs(botID, groupID, userID, n) {
+		text := strings.TrimSpace(msg.Message.PlainText())
+		if text == "" {
+			continue
+		}
+		name := msg.Nickname
+		if name == "" {
+			name = fmt.Sprint(msg.UserID)
+		}
+		fmt.Fprintf(&sb, "%v: %v\n", name, text)
+	}
+	return sb.String()
+}
+
+// QuotedMsg returns the message quoted by the reply segment of the event.
+func (e *Event) QuotedMsg(bInfo BotInfo) (HistoryMsg, bool) {
+	id, ok := e.GetReplyID()
+	if !ok {
+		return HistoryMsg{}, false
+	}
+	return GetHistoryMsg(bInfo.BotID, id)
+}
+
+func loadHistory() error {
+	if !CurConf().History.Persist {
+		return nil
+	}
+	var saved map[string][]HistoryMsg
+	if err := LoadData(historyDataName, &saved); err != nil {
+		return err
+	}
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	for key, msgs := range saved {
+		for _, msg := range msgs {
+			pushHistory(key, msg)
+		}
+	}
+	return nil
+}
+
+// saveHistory saves the history if it is changed.
+func saveHistory() error {
+	historyLock.Lock()
+	if !historyDirty {
+		historyLock.Unlock()
+		return nil
+	}
+	saved := make(map[string][]HistoryMsg, len(histories))
+	for key, ring := range histories {
+		saved[key] = ring.list()
+	}
+	historyDirty = false
+	historyLock.Unlock()
+	return SaveData(historyDataName, saved)
+}
+
+func runHistoryFlusher() {
+	go func() {
+		for {
+			interval := CurConf().History.FlushInterval
+			time.Sleep(time.Duration(interval) * time.Second)
+			if !CurConf().History.Persist {
+				continue
+			}
+			if err := saveHistory(); err != nil {
+				LBLogger.Warnln(T("history.save-fail", err))
+			}
+		}
+	}()
+}
@@ -0,0 +1,202 @@
+package luxtbot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useHistory enables the history of the size with no messages until the test ends.
+func useHistory(t *testing.T, size int) {
+	conf := *CurConf()
+	conf.History = HistoryConf{Enable: true, Size: size}
+	useConf(t, conf)
+	historyLock.Lock()
+	oldHistories, oldIndex := histories, historyIndex
+	histories = make(map[string]*historyRing)
+	historyIndex = make(map[historyKey]string)
+	historyLock.Unlock()
+	t.Cleanup(func() {
+		historyLock.Lock()
+		histories, historyIndex = oldHistories, oldIndex
+		historyLock.Unlock()
+	})
+}
+
+func historyIDs(msgs []HistoryMsg) []int {
+	ids := []int{}
+	for _, msg := range msgs {
+		ids = append(ids, msg.MessageID)
+	}
+	return ids
+}
+
+func TestHistoryRing(t *testing.T) {
+	tests := []struct {
+		name        string
+		sizes       []int
+		wantList    []int
+		wantEvicted []int
+	}{
+		{"not full", []int{3, 3}, []int{1, 2}, []int{}},
+		{"full", []int{3, 3, 3}, []int{1, 2, 3}, []int{}},
+		{"wrapped", []int{3, 3, 3, 3, 3}, []int{3, 4, 5}, []int{1, 2}},
+		{"grown", []int{2, 2, 2, 4, 4}, []int{2, 3, 4, 5}, []int{1}},
+		// the oldest ones over the new size are evicted first
+		{"shrunk", []int{3, 3, 3, 3, 2}, []int{4, 5}, []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				r       historyRing
+				evicted []HistoryMsg
+			)
+			for i, size := range tt.sizes {
+				evicted = append(evicted, r.push(HistoryMsg{MessageID: i + 1}, size)...)
+			}
+			if got := historyIDs(r.list()); !reflect.DeepEqual(got, tt.wantList) {
+				t.Errorf("list() = %v, want %v", got, tt.wantList)
+			}
+			if got := historyIDs(evicted); !reflect.DeepEqual(got, tt.wantEvicted) {
+				t.Errorf("evicted %v, want %v", got, tt.wantEvicted)
+			}
+		})
+	}
+}
+
+func TestGetHistoryMsg(t *testing.T) {
+	useHistory(t, 2)
+	addHistory(HistoryMsg{MessageID: 1, BotID: 10, GroupID: 100}, 0)
+	addHistory(HistoryMsg{MessageID: 1, BotID: 11, GroupID: 100}, 0)
+	addHistory(HistoryMsg{MessageID: 2, BotID: 10, GroupID: 100}, 0)
+	addHistory(HistoryMsg{MessageID: 3, BotID: 10, GroupID: 100}, 0)
+	addHistory(HistoryMsg{MessageID: 4, BotID: 10, UserID: 1}, 1)
+	tests := []struct {
+		botID     int64
+		messageID int
+		want      bool
+	}{
+		// evicted from the group chat
+		{10, 1, false},
+		// the same message_id of another bot is kept
+		{11, 1, true},
+		{10, 2, true},
+		{10, 3, true},
+		{10, 4, true},
+		{10, 5, false},
+	}
+	for _, tt := range tests {
+		msg, ok := GetHistoryMsg(tt.botID, tt.messageID)
+		if ok != tt.want || ok && (msg.BotID != tt.botID || msg.MessageID != tt.messageID) {
+			t.Errorf("GetHistoryMsg(%d, %d) = %+v, %v, want %v", tt.botID, tt.messageID, msg, ok, tt.want)
+		}
+	}
+	historyLock.RLock()
+	defer historyLock.RUnlock()
+	if len(historyIndex) != 4 {
+		t.Errorf("the index of the evicted messages is kept: %v", historyIndex)
+	}
+}
+
+func TestRecentMsgs(t *testing.T) {
+	useHistory(t, 10)
+	for i, outgoing := range []bool{false, true, false, true, true} {
+		addHistory(HistoryMsg{MessageID: i + 1, BotID: 10, GroupID: 100, Outgoing: outgoing}, 0)
+	}
+	// sent by an async action, so that it could not be recalled
+	addHistory(HistoryMsg{BotID: 10, GroupID: 100, Outgoing: true}, 0)
+	addHistory(HistoryMsg{MessageID: 7, BotID: 10, UserID: 100}, 100)
+	tests := []struct {
+		name string
+		got  []HistoryMsg
+		want []int
+	}{
+		{"recent", RecentMsgs(10, 100, 0, 3), []int{4, 5, 0}},
+		{"all", RecentMsgs(10, 100, 0, 0), []int{1, 2, 3, 4, 5, 0}},
+		{"private", RecentMsgs(10, 0, 100, 0), []int{7}},
+		{"other bot", RecentMsgs(11, 100, 0, 0), []int{}},
+		{"bot last", BotLastMsgs(10, 100, 0, 2), []int{5, 4}},
+		{"bot last all", BotLastMsgs(10, 100, 0, 10), []int{5, 4, 2}},
+		{"bot last private", BotLastMsgs(10, 0, 100, 10), []int{}},
+	}
+	for _, tt := range tests {
+		if got := historyIDs(tt.got); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// a message sent is kept when its response comes with the message_id
+func TestRecordSent(t *testing.T) {
+	useHistory(t, 10)
+	bInfo := BotInfo{BotID: 10, Name: "bot"}
+	tests := []struct {
+		name     string
+		api      ApiPost
+		resp     ApiResp
+		wantEcho string
+		wantChat [2]int64
+		wantKept bool
+	}{
+		{
+			name:     "group",
+			api:      makeApi(GroupMsgAction, &GroupMsg{GroupID: 100, Message: "hi"}),
+			resp:     ApiResp{Status: RespStatusOK},
+			wantEcho: historyEchoPrefix,
+			wantChat: [2]int64{100, 0},
+			wantKept: true,
+		},
+		{
+			name:     "echo kept",
+			api:      ApiPost{Action: PrivateMsgAction, Params: PrivateMsg{UserID: 1, Message: "hi"}, Echo: "mine"},
+			resp:     ApiResp{Status: RespStatusOK},
+			wantEcho: "mine",
+			wantChat: [2]int64{0, 1},
+			wantKept: true,
+		},
+		{
+			name:     "failed",
+			api:      makeApi(GroupMsgAction, &GroupMsg{GroupID: 100, Message: "hi"}),
+			resp:     ApiResp{Status: RespStatusFailed, Retcode: 100},
+			wantEcho: historyEchoPrefix,
+			wantChat: [2]int64{100, 0},
+		},
+		{
+			name: "not a message",
+			api:  makeApi(DeleteMsgAction, map[string]interface{}{"message_id": 1}),
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordSent(&tt.api, bInfo)
+			if tt.wantEcho == "" {
+				if tt.api.Echo != "" {
+					t.Errorf("echo %q is given", tt.api.Echo)
+				}
+				return
+			}
+			if !strings.HasPrefix(tt.api.Echo, tt.wantEcho) {
+				t.Errorf("echo = %q, want %q", tt.api.Echo, tt.wantEcho)
+			}
+			tt.resp.Echo = tt.api.Echo
+			tt.resp.Data.MessageID = 1000 + i
+			runApiRespHooks(&tt.resp, bInfo)
+			msg, ok := GetHistoryMsg(10, 1000+i)
+			if ok != tt.wantKept {
+				t.Fatalf("kept %v, want %v", ok, tt.wantKept)
+			}
+			if _, pending := pendingSent.Get(tt.api.Echo); pending {
+				t.Error("the message is still pending")
+			}
+			if !ok {
+				return
+			}
+			if !msg.Outgoing || msg.UserID != 10 || msg.Nickname != "bot" || msg.Message.String() != "hi" {
+				t.Errorf("kept %+v", msg)
+			}
+			if got := RecentMsgs(10, tt.wantChat[0], tt.wantChat[1], 1); len(got) != 1 || got[0].MessageID != 1000+i {
+				t.Errorf("not kept in the chat %v: %+v", tt.wantChat, got)
+			}
+		})
+	}
+}
@@ -32,6 +32,8 @@ func MakeDisconnectHook(task func(bInfo BotInfo)) DisconnectHook{
 // all of the EventInHook will be called.
 // If the hook return a not nil error,
 // the event will be aborted.
+// These hooks run as one middleware, see UseEventIn
+// for the ordered and removable ones.
 type EventInHook func(e *Event, bInfo BotInfo) error
 
 func (hook EventInHook) AddToHookChain() {
@@ -46,7 +48,10 @@ func MakeEventInHook(task func(e *Event, bInfo BotInfo) error) EventInHook{
 // Before send an ApiPost to the CQ server,
 // all of the  BeforeApiOutHook will be called.
 // if the hook return a not nil error,
-// this ApiPost will be aborted
+// this ApiPost will be aborted.
+// Params of the api of sending messages is a pointer, like *GroupMsg.
+// These hooks run as one middleware, see UseApiOut
+// for the ordered and removable ones.
 type BeforeApiOutHook func(apiPost *ApiPost ,bInfo BotInfo) error
 
 func (hook BeforeApiOutHook) AddToHookChain() {
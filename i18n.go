@@ -0,0 +1,371 @@
+package luxtbot
+
+import (
+	"fmt"
+	"sync"
+)
+
+const LocaleEnUS = "en-US"
+
+var (
+	catalog = map[string]map[string]string{
+		DefaultLocale: catalogZhCN,
+		LocaleEnUS:    catalogEnUS,
+	}
+	catalogLock sync.RWMutex
+)
+
+// AddCatalog adds or overrides the framework strings of a locale,
+// see catalogEnUS for the keys.
+func AddCatalog(locale string, msgs map[string]string) {
+	catalogLock.Lock()
+	defer catalogLock.Unlock()
+	if catalog[locale] == nil {
+		catalog[locale] = make(map[string]string)
+	}
+	for key, msg := range msgs {
+		catalog[locale][key] = msg
+	}
+}
+
+// T returns the string of key in the locale of the config, falling back to
+// DefaultLocale, formatted with args if any. An unknown key is returned as it is.
+func T(key string, args ...interface{}) string {
+	catalogLock.RLock()
+	msg, ok := catalog[CurConf().Locale][key]
+	if !ok {
+		msg, ok = catalog[DefaultLocale][key]
+	}
+	catalogLock.RUnlock()
+	if !ok {
+		msg = key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// Error is an error of the framework, its message is translated by T,
+// so compare it by errors.Is rather than the message.
+type Error string
+
+func (e Error) Error() string {
+	return T(string(e))
+}
+
+const (
+	ErrBotNotFound  Error = "err.bot-not-found"
+	ErrBotExists    Error = "err.bot-exists"
+	ErrSendTimeout  Error = "err.send-timeout"
+	ErrRespTimeout  Error = "err.resp-timeout"
+	ErrEmptyMessage Error = "err.empty-message"
+	ErrApiFailed    Error = "err.api-failed"
+	ErrApiAborted   Error = "err.api-aborted"
+)
+
+var catalogZhCN = map[string]string{
+	"err.bot-not-found":  "无法找到该ID的Bot实例。",
+	"err.bot-exists":     "该ID的Bot实例已存在。",
+	"err.send-timeout":   "发送消息超时。",
+	"err.resp-timeout":   "等待API回复超时。",
+	"err.empty-message":  "消息为空。",
+	"err.api-failed":     "API调用失败",
+	"err.api-aborted":    "API被钩子中止",
+	"err.seg-count":      "消息段数与预期不符，检查创建方式是否正确",
+	"err.data-format":    "读入数据解析失败，数据格式异常。",
+	"err.reply-type":     "不支持回复该类型的消息：%v",
+	"err.no-conf-path":   "未指定配置文件路径，无法保存配置。",
+	"err.bot-info":       "Bot信息错误：%v",
+	"err.tmpl-not-found": "插件%v未找到模板：%v",
+	"err.tmpl-file":      "模板文件格式错误 %v: %v",
+	"err.tmpl":           "模板错误 %v %v: %v",
+	"err.plg-conf-ptr":   "插件配置必须是指向结构体的指针：%v",
+	"err.plg-conf-def":   "插件配置默认值错误 %v: %v",
+	"err.plg-conf-reg":   "插件%v配置注册失败：%v",
+
+	"rule.eof":           "表达式不完整",
+	"rule.unexpected":    "第%[2]d个字符处意外的%[1]q",
+	"rule.unknown-field": "未知的字段：%v",
+	"rule.bad-op":        "字段%v不支持%v",
+	"rule.unknown-list":  "未定义的列表：%v",
+	"rule.bad-value":     "无效的值：%v",
+
+	"conf.invalid":          "配置校验失败：%v",
+	"conf.role-perm":        "roles.%v: 权限不能为空",
+	"conf.filter-policy":    "filter.policy: 无效的策略：%v",
+	"conf.filter-file":      "filter.word-files: 读取词表失败 %v: %v",
+	"conf.open":             "打开配置文件失败：%v",
+	"conf.file":             "配置文件 %v: %v",
+	"conf.parse":            "解析配置文件失败 %v: %v",
+	"conf.env-override":     "环境变量覆盖配置失败：%v",
+	"conf.file-invalid":     "配置文件校验失败 %v: %v",
+	"conf.format":           "不支持的配置文件格式：%v",
+	"conf.env-undefined":    "环境变量未定义：%v",
+	"conf.env-id":           "%vS_ADMIN: id格式错误：%v",
+	"conf.env-port":         "%vPORT: 端口格式错误：%v",
+	"conf.dup-id":           "bots[%d].id: 重复的id：%d",
+	"conf.log-level":        "log.level: 无效的日志等级：%v",
+	"conf.font":             "render.font: 字体文件不可用：%v",
+	"conf.bot-id":           "id: 必须填写有效的QQ号",
+	"conf.host":             "host: 不能为空",
+	"conf.port":             "port: 必须在1-65535之间：%d",
+	"conf.msg-type":         "message-type: 必须为%v或%v：%q",
+	"conf.negative":         "%v: 不能为负数：%d",
+	"conf.changed":          "配置变更 %v: %v -> %v",
+	"conf.changed-restart":  "配置变更 %v: %v -> %v，重启后生效",
+	"conf.changed-key":      "配置变更 %v",
+	"conf.changed-key-rest": "配置变更 %v，重启后生效",
+	"conf.bot-added":        "配置变更：新增Bot %v",
+	"conf.bot-changed":      "配置变更：%v",
+	"conf.bot-removed":      "配置变更：移除Bot %v",
+	"conf.token-changed":    "access-token已修改",
+	"conf.reconnect":        "连接配置已变更，重新连接CQ server。",
+	"conf.section-unused":   "没有插件声明该配置，将忽略。",
+	"conf.sighup":           "收到SIGHUP信号，重新加载配置文件。",
+	"conf.watching":         "已开启配置文件热加载，检查间隔为：%v",
+	"conf.modified":         "配置文件已修改，重新加载配置文件。",
+	"conf.reload-failed":    "重新加载配置文件失败，继续使用原配置：%v",
+
+	"bot.added":         "已添加Bot实例。",
+	"bot.stopped":       "Bot已停止。",
+	"bot.removed":       "已移除Bot实例。",
+	"bot.connecting":    "尝试连接第%v次",
+	"bot.connect-fail":  "连接CQ server 失败。将在3秒后重试。",
+	"bot.online":        "Bot已经上线。",
+	"bot.heart-start":   "将在15秒之后开启心跳检测，超时时间为：%v",
+	"bot.disabled":      "已禁用该Bot",
+	"bot.heart-fail":    "Bot心跳检测失败，尝试重新连接",
+	"bot.read-fail":     "Bot消息读取异常",
+	"bot.write-fail":    "Bot消息发送异常",
+	"bot.closing":       "正在尝试关闭已有连接",
+	"bot.close-fail":    "关闭连接异常！尚存在数据未读取 %v",
+	"bot.id-mismatch":   "CQ Server 账号ID与所配置的ID无法匹配，即将禁用该Bot",
+	"bot.no-callback":   "找不到api回复回调函数。",
+	"msg.dropped":       "检查到消息异常，将放弃该条消息：%v",
+	"msg.text-img-fail": "文字转图片失败，将以文字发送：%v",
+	"plugin.start":      "启动插件：%v %v",
+	"exec.panic":        "插件处理事件时发生panic：%v",
+	"exec.timeout":      "插件处理事件超时：%v",
+	"exec.skipped":      "插件处理被钩子跳过：%v",
+	"exec.rule-panic":   "插件规则判断时发生panic：%v",
+	"exec.hook-panic":   "钩子%v发生panic：%v",
+	"err.unit-timeout":  "插件处理事件超时",
+	"err.hook-panic":    "钩子发生panic",
+
+	"plugin.no-info":       "写该插件的人很懒，没有留下任何信息！",
+	"plugin.mgr-info":      "Luxtbot默认插件管理",
+	"plugin.mgr-name":      "Luxtbot插件管理",
+	"plugin.on":            "已开启插件：%v %v",
+	"plugin.off":           "已关闭插件：%v %v",
+	"plugin.self":          "无法禁用或开启插件管理插件！",
+	"plugin.bad-id":        "插件id格式错误：%v",
+	"plugin.not-found":     "未找到插件：%v",
+	"botmgr.info":          "Luxtbot默认Bot实例管理",
+	"botmgr.name":          "Luxtbot实例管理",
+	"botmgr.added":         "已添加并启动Bot：%v %v",
+	"botmgr.started":       "已启动Bot：%v",
+	"botmgr.stopped":       "已停止Bot：%v",
+	"botmgr.removed":       "已移除Bot：%v",
+	"botmgr.self":          "无法通过Bot自身停止或移除该Bot！",
+	"botmgr.no-id":         "缺少Bot ID参数。",
+	"botmgr.bad-id":        "Bot ID格式错误：%v",
+	"botmgr.botadd-params": "参数不足：botadd <id> <host> <port> [token] [name]",
+	"botmgr.bad-port":      "端口格式错误：%v",
+	"botmgr.private-only":  "为避免泄露token，请私聊使用该命令。",
+
+	"perm.info":        "Luxtbot默认权限管理",
+	"perm.name":        "Luxtbot权限管理",
+	"perm.load":        "加载权限数据失败：%v",
+	"perm.bad-grant":   "授权对象或权限为空。",
+	"perm.not-granted": "未授予该权限：%v",
+	"perm.usage":       "用法：%v user <QQ> | group <群号> | member <群号> <QQ> [权限或角色]",
+	"perm.bad-id":      "ID格式错误：%v",
+	"perm.granted":     "已授权：%v",
+	"perm.revoked":     "已撤销授权：%v",
+	"perm.list":        "已授予：%v",
+
+	"err.event-blocked":  "已忽略黑名单用户或Bot的事件",
+	"err.msg-filtered":   "消息含有屏蔽内容，已丢弃",
+	"err.event-dup":      "已忽略重复的消息",
+	"err.no-bot":         "没有可以发送到该群的在线Bot",
+	"failover.failed":    "Bot发送群消息失败，将尝试其他Bot：%v",
+	"info.warm-fail":     "获取好友和群信息失败：%v",
+	"info.group-fail":    "获取群 %d 的信息失败：%v",
+	"history.load":       "加载消息记录失败：%v",
+	"history.save-fail":  "保存消息记录失败：%v",
+	"filter.replacement": "[已屏蔽]",
+	"filter.audit":       "消息含有屏蔽内容：%v，丢弃：%v",
+	"filter.audit-fail":  "写入过滤审计日志失败：%v",
+	"filter.bad-params":  "无法检查该API的消息，已丢弃：%v",
+	"block.info":         "Luxtbot默认黑名单管理",
+	"block.name":         "Luxtbot黑名单管理",
+	"block.load":         "加载黑名单失败：%v",
+	"block.no-user":      "未指定用户。",
+	"block.not-found":    "该用户不在此范围的黑名单中：%v",
+	"block.bad-id":       "QQ格式错误：%v",
+	"block.bad-duration": "时长格式错误：%v，如30m、2h、7d，0为永久",
+	"block.usage":        "用法：block <QQ> [all|bot|group|<群号>] [时长] [原因]",
+	"block.blocked":      "已拉黑：%v",
+	"block.unblocked":    "已解除拉黑：%v",
+	"block.empty":        "黑名单为空。",
+	"block.scope-all":    "全局",
+	"block.scope-bot":    "Bot %v",
+	"block.scope-group":  "群 %v",
+	"block.never":        "永久",
+	"block.sadmin":       "不能拉黑超级管理员：%v",
+	"block.all-sadmin":   "只有超级管理员可以全局拉黑。",
+}
+
+var catalogEnUS = map[string]string{
+	"err.bot-not-found":  "No bot instance with this ID.",
+	"err.bot-exists":     "A bot instance with this ID already exists.",
+	"err.send-timeout":   "Sending the message timed out.",
+	"err.resp-timeout":   "Waiting for the API response timed out.",
+	"err.empty-message":  "The message is empty.",
+	"err.api-failed":     "API call failed",
+	"err.api-aborted":    "The api is aborted by a hook",
+	"err.seg-count":      "The count of segments is unexpected, check how the message is built",
+	"err.data-format":    "Failed to parse the data read, bad format.",
+	"err.reply-type":     "Could not reply to this type of message: %v",
+	"err.no-conf-path":   "No config file path, could not save the config.",
+	"err.bot-info":       "Bad bot info: %v",
+	"err.tmpl-not-found": "Template of plugin %v not found: %v",
+	"err.tmpl-file":      "Bad template file %v: %v",
+	"err.tmpl":           "Bad template %v %v: %v",
+	"err.plg-conf-ptr":   "The plugin config must be a pointer to a struct: %v",
+	"err.plg-conf-def":   "Bad default plugin config %v: %v",
+	"err.plg-conf-reg":   "Failed to register the config of plugin %v: %v",
+
+	"rule.eof":           "incomplete expression",
+	"rule.unexpected":    "unexpected %q at %d",
+	"rule.unknown-field": "unknown field: %v",
+	"rule.bad-op":        "field %v does not support %v",
+	"rule.unknown-list":  "undefined list: %v",
+	"rule.bad-value":     "bad value: %v",
+
+	"conf.invalid":          "Invalid config: %v",
+	"conf.role-perm":        "roles.%v: empty permission",
+	"conf.filter-policy":    "filter.policy: bad policy: %v",
+	"conf.filter-file":      "filter.word-files: failed to read the words %v: %v",
+	"conf.open":             "Failed to open the config file: %v",
+	"conf.file":             "Config file %v: %v",
+	"conf.parse":            "Failed to parse the config file %v: %v",
+	"conf.env-override":     "Failed to override the config by environment variables: %v",
+	"conf.file-invalid":     "Invalid config file %v: %v",
+	"conf.format":           "Unsupported config file format: %v",
+	"conf.env-undefined":    "Undefined environment variables: %v",
+	"conf.env-id":           "%vS_ADMIN: bad id: %v",
+	"conf.env-port":         "%vPORT: bad port: %v",
+	"conf.dup-id":           "bots[%d].id: duplicate id: %d",
+	"conf.log-level":        "log.level: invalid log level: %v",
+	"conf.font":             "render.font: font file unavailable: %v",
+	"conf.bot-id":           "id: must be a valid QQ number",
+	"conf.host":             "host: must not be empty",
+	"conf.port":             "port: must be in 1-65535: %d",
+	"conf.msg-type":         "message-type: must be %v or %v: %q",
+	"conf.negative":         "%v: must not be negative: %d",
+	"conf.changed":          "Config changed %v: %v -> %v",
+	"conf.changed-restart":  "Config changed %v: %v -> %v, takes effect after restarting",
+	"conf.changed-key":      "Config changed %v",
+	"conf.changed-key-rest": "Config changed %v, takes effect after restarting",
+	"conf.bot-added":        "Config changed: bot added %v",
+	"conf.bot-changed":      "Config changed: %v",
+	"conf.bot-removed":      "Config changed: bot removed %v",
+	"conf.token-changed":    "access-token changed",
+	"conf.reconnect":        "Connection config changed, reconnecting to the CQ server.",
+	"conf.section-unused":   "No plugin declares this config, ignored.",
+	"conf.sighup":           "Received SIGHUP, reloading the config file.",
+	"conf.watching":         "Config hot reload is on, checking every %v",
+	"conf.modified":         "Config file modified, reloading the config file.",
+	"conf.reload-failed":    "Failed to reload the config file, keeping the current config: %v",
+
+	"bot.added":         "Bot instance added.",
+	"bot.stopped":       "Bot stopped.",
+	"bot.removed":       "Bot instance removed.",
+	"bot.connecting":    "Connecting, attempt %v",
+	"bot.connect-fail":  "Failed to connect to the CQ server, retrying in 3 seconds.",
+	"bot.online":        "Bot is online.",
+	"bot.heart-start":   "Heart check starts in 15 seconds, timeout: %v",
+	"bot.disabled":      "Bot disabled",
+	"bot.heart-fail":    "Bot heart check failed, reconnecting",
+	"bot.read-fail":     "Failed to read from the bot",
+	"bot.write-fail":    "Failed to send to the bot",
+	"bot.closing":       "Closing the current connection",
+	"bot.close-fail":    "Failed to close the connection, data left unread %v",
+	"bot.id-mismatch":   "The account ID of the CQ server does not match the configured ID, disabling the bot",
+	"bot.no-callback":   "No callback for the api response.",
+	"msg.dropped":       "Bad message, dropped: %v",
+	"msg.text-img-fail": "Failed to render text into an image, sending as text: %v",
+	"plugin.start":      "Starting plugin: %v %v",
+	"exec.panic":        "Plugin panicked processing the event: %v",
+	"exec.timeout":      "Plugin timed out processing the event: %v",
+	"exec.skipped":      "Unit skipped by a hook: %v",
+	"exec.rule-panic":   "Rule of the unit panicked: %v",
+	"exec.hook-panic":   "Hook %v panicked: %v",
+	"err.unit-timeout":  "Plugin timed out processing the event",
+	"err.hook-panic":    "A hook panicked",
+
+	"plugin.no-info":       "The author of this plugin left no information!",
+	"plugin.mgr-info":      "Luxtbot default plugin manager",
+	"plugin.mgr-name":      "Luxtbot plugin manager",
+	"plugin.on":            "Plugin enabled: %v %v",
+	"plugin.off":           "Plugin disabled: %v %v",
+	"plugin.self":          "Could not enable or disable the plugin manager!",
+	"plugin.bad-id":        "Bad plugin id: %v",
+	"plugin.not-found":     "Plugin not found: %v",
+	"botmgr.info":          "Luxtbot default bot manager",
+	"botmgr.name":          "Luxtbot bot manager",
+	"botmgr.added":         "Bot added and started: %v %v",
+	"botmgr.started":       "Bot started: %v",
+	"botmgr.stopped":       "Bot stopped: %v",
+	"botmgr.removed":       "Bot removed: %v",
+	"botmgr.self":          "Could not stop or remove a bot by itself!",
+	"botmgr.no-id":         "Missing the bot ID.",
+	"botmgr.bad-id":        "Bad bot ID: %v",
+	"botmgr.botadd-params": "Not enough params: botadd <id> <host> <port> [token] [name]",
+	"botmgr.bad-port":      "Bad port: %v",
+	"botmgr.private-only":  "Use this command in a private message, so that the token is not leaked.",
+
+	"perm.info":        "Luxtbot default permission manager",
+	"perm.name":        "Luxtbot permission manager",
+	"perm.load":        "Failed to load the permission data: %v",
+	"perm.bad-grant":   "Empty grant target or permission.",
+	"perm.not-granted": "Not granted: %v",
+	"perm.usage":       "Usage: %v user <QQ> | group <group id> | member <group id> <QQ> [permission or role]",
+	"perm.bad-id":      "Bad ID: %v",
+	"perm.granted":     "Granted: %v",
+	"perm.revoked":     "Revoked: %v",
+	"perm.list":        "Granted: %v",
+
+	"err.event-blocked":  "Ignored the event of a blocked user or bot",
+	"err.msg-filtered":   "The message has filtered content and is dropped",
+	"err.event-dup":      "Ignored the duplicated message",
+	"err.no-bot":         "No online bot could send to the group",
+	"failover.failed":    "The bot failed to send the group message, trying other bots: %v",
+	"info.warm-fail":     "Failed to get the friends and the groups: %v",
+	"info.group-fail":    "Failed to get the info of group %d: %v",
+	"history.load":       "Failed to load the message history: %v",
+	"history.save-fail":  "Failed to save the message history: %v",
+	"filter.replacement": "[filtered]",
+	"filter.audit":       "The message has filtered content: %v, dropped: %v",
+	"filter.audit-fail":  "Failed to write the filter audit log: %v",
+	"filter.bad-params":  "Could not check the message of the api, dropped: %v",
+	"block.info":         "Luxtbot default blacklist manager",
+	"block.name":         "Luxtbot blacklist manager",
+	"block.load":         "Failed to load the blacklist: %v",
+	"block.no-user":      "No user given.",
+	"block.not-found":    "The user is not blocked in this scope: %v",
+	"block.bad-id":       "Bad QQ: %v",
+	"block.bad-duration": "Bad duration: %v, like 30m, 2h or 7d, 0 for never",
+	"block.usage":        "Usage: block <QQ> [all|bot|group|<group id>] [duration] [reason]",
+	"block.blocked":      "Blocked: %v",
+	"block.unblocked":    "Unblocked: %v",
+	"block.empty":        "The blacklist is empty.",
+	"block.scope-all":    "global",
+	"block.scope-bot":    "bot %v",
+	"block.scope-group":  "group %v",
+	"block.never":        "never",
+	"block.sadmin":       "Super admins could not be blocked: %v",
+	"block.all-sadmin":   "Only super admins could block globally.",
+}
@@ -0,0 +1,407 @@
+package luxtbot
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	NoticeGroupIncrease = "group_increase"
+	NoticeGroupDecrease = "group_decrease"
+	NoticeGroupAdmin    = "group_admin"
+	NoticeGroupCard     = "group_card"
+	NoticeFriendAdd     = "friend_add"
+
+	DefaultInfoCacheTTL = 3600
+
+	infoCacheHookName = "luxtbot.infocache"
+)
+
+type FriendInfo struct {
+	UserID   int64  `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Remark   string `json:"remark"`
+}
+
+type GroupInfo struct {
+	GroupID        int64  `json:"group_id"`
+	GroupName      string `json:"group_name"`
+	MemberCount    int    `json:"member_count"`
+	MaxMemberCount int    `json:"max_member_count"`
+}
+
+type MemberInfo struct {
+	GroupID      int64  `json:"group_id"`
+	UserID       int64  `json:"user_id"`
+	Nickname     string `json:"nickname"`
+	Card         string `json:"card"`
+	Sex          string `json:"sex"`
+	Age          int    `json:"age"`
+	Area         string `json:"area"`
+	JoinTime     int64  `json:"join_time"`
+	LastSentTime int64  `json:"last_sent_time"`
+	Level        string `json:"level"`
+	Role         string `json:"role"`
+	Title        string `json:"title"`
+}
+
+// DisplayName returns the card, or the nickname if the card is empty.
+func (mi *MemberInfo) DisplayName() string {
+	if mi.Card != "" {
+		return mi.Card
+	}
+	return mi.Nickname
+}
+
+// infoCache keeps the friends, the groups and the members known by a bot.
+// It is warmed when the bot connects, updated by the notices and the senders
+// of the messages, and the parts older than info-cache-ttl are fetched again
+// when queried.
+type infoCache struct {
+	lock      sync.RWMutex
+	friends   map[int64]FriendInfo
+	friendsAt time.Time
+	groups    map[int64]GroupInfo
+	groupsAt  time.Time
+	// the time of the groups fetched one by one
+	groupAt map[int64]time.Time
+	members map[int64]*memberList
+}
+
+type memberList struct {
+	members map[int64]cachedMember
+	// the time of the whole list fetched
+	at time.Time
+}
+
+type cachedMember struct {
+	info MemberInfo
+	at   time.Time
+}
+
+func init() {
+	onConnectChain = append(onConnectChain, warmInfoCacheHook)
+	UseEventIn(infoCacheHookName, LegacyHookPriority-1, func(c *EventInCtx, next func() error) error {
+		c.Bot.cache.update(c.Event, c.Bot)
+		return next()
+	})
+}
+
+func newInfoCache() *infoCache {
+	return &infoCache{
+		friends: make(map[int64]FriendInfo),
+		groups:  make(map[int64]GroupInfo),
+		groupAt: make(map[int64]time.Time),
+		members: make(map[int64]*memberList),
+	}
+}
+
+func infoCacheTTL() time.Duration {
+	return time.Duration(CurConf().InfoCacheTTL) * time.Second
+}
+
+func fresh(at time.Time) bool {
+	return !at.IsZero() && time.Since(at) < infoCacheTTL()
+}
+
+// warmInfoCacheHook fetches the friends and the groups when a bot connects,
+// which is done in a new goroutine since the responses are not received
+// until the OnconnectHooks return.
+func warmInfoCacheHook(bInfo BotInfo) error {
+	bCtx, err := getBotCtxByID(bInfo.BotID)
+	if err != nil {
+		return nil
+	}
+	go func() {
+		if _, err := bCtx.refreshFriends(); err != nil {
+			LBLogger.WithField("BotName", bInfo.Name).Warnln(T("info.warm-fail", err))
+		}
+		if _, err := bCtx.refreshGroups(); err != nil {
+			LBLogger.WithField("BotName", bInfo.Name).Warnln(T("info.warm-fail", err))
+		}
+	}()
+	return nil
+}
+
+func (b *BotContext) callInfoApi(action string, params interface{}, v interface{}) error {
+	resp, err := makeApi(action, params).DoWithResp(b.Info().BotID, 0)
+	if err != nil {
+		return err
+	}
+	return resp.DecodeData(v)
+}
+
+func (b *BotContext) refreshFriends() ([]FriendInfo, error) {
+	var list []FriendInfo
+	if err := b.callInfoApi("get_friend_list", struct{}{}, &list); err != nil {
+		return nil, err
+	}
+	c := b.cache
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.friends = make(map[int64]FriendInfo, len(list))
+	for _, fi := range list {
+		c.friends[fi.UserID] = fi
+	}
+	c.friendsAt = time.Now()
+	return list, nil
+}
+
+func (b *BotContext) refreshGroups() ([]GroupInfo, error) {
+	var list []GroupInfo
+	if err := b.callInfoApi("get_group_list", struct{}{}, &list); err != nil {
+		return nil, err
+	}
+	c := b.cache
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.groups = make(map[int64]GroupInfo, len(list))
+	for _, gi := range list {
+		c.groups[gi.GroupID] = gi
+	}
+	c.groupsAt = time.Now()
+	return list, nil
+}
+
+func (b *BotContext) refreshMembers(groupID int64) ([]MemberInfo, error) {
+	var list []MemberInfo
+	if err := b.callInfoApi("get_group_member_list", map[string]interface{}{"group_id": groupID, "no_cache": true}, &list); err != nil {
+		return nil, err
+	}
+	c := b.cache
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	ml := &memberList{members: make(map[int64]cachedMember, len(list)), at: now}
+	for _, mi := range list {
+		ml.members[mi.UserID] = cachedMember{info: mi, at: now}
+	}
+	c.members[groupID] = ml
+	return list, nil
+}
+
+// Friends returns the friends of the bot.
+func (b *BotContext) Friends() ([]FriendInfo, error) {
+	c := b.cache
+	c.lock.RLock()
+	if fresh(c.friendsAt) {
+		list := make([]FriendInfo, 0, len(c.friends))
+		for _, fi := range c.friends {
+			list = append(list, fi)
+		}
+		c.lock.RUnlock()
+		return list, nil
+	}
+	c.lock.RUnlock()
+	return b.refreshFriends()
+}
+
+// IsFriend reports whether the user is a friend of the bot, by the cache only.
+func (b *BotContext) IsFriend(userID int64) bool {
+	c := b.cache
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	_, ok := c.friends[userID]
+	return ok
+}
+
+// Groups returns the groups the bot is in.
+func (b *BotContext) Groups() ([]GroupInfo, error) {
+	c := b.cache
+	c.lock.RLock()
+	if fresh(c.groupsAt) {
+		list := make([]GroupInfo, 0, len(c.groups))
+		for _, gi := range c.groups {
+			list = append(list, gi)
+		}
+		c.lock.RUnlock()
+		return list, nil
+	}
+	c.lock.RUnlock()
+	return b.refreshGroups()
+}
+
+// InGroup reports whether the bot is in the group, by the cache only.
+func (b *BotContext) InGroup(groupID int64) bool {
+	c := b.cache
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	_, ok := c.groups[groupID]
+	return ok
+}
+
+// Group returns the info of a group the bot is in.
+func (b *BotContext) Group(groupID int64) (GroupInfo, error) {
+	c := b.cache
+	c.lock.RLock()
+	gi, ok := c.groups[groupID]
+	isFresh := fresh(c.groupsAt) || fresh(c.groupAt[groupID])
+	c.lock.RUnlock()
+	if ok && isFresh {
+		return gi, nil
+	}
+	if err := b.callInfoApi("get_group_info", map[string]interface{}{"group_id": groupID, "no_cache": true}, &gi); err != nil {
+		return gi, err
+	}
+	c.lock.Lock()
+	c.groups[groupID] = gi
+	c.groupAt[groupID] = time.Now()
+	c.lock.Unlock()
+	return gi, nil
+}
+
+// Members returns the members of the group.
+func (b *BotContext) Members(groupID int64) ([]MemberInfo, error) {
+	c := b.cache
+	c.lock.RLock()
+	if ml := c.members[groupID]; ml != nil && fresh(ml.at) {
+		list := make([]MemberInfo, 0, len(ml.members))
+		for _, cm := range ml.members {
+			list = append(list, cm.info)
+		}
+		c.lock.RUnlock()
+		return list, nil
+	}
+	c.lock.RUnlock()
+	return b.refreshMembers(groupID)
+}
+
+// Member returns the info of a member of the group.
+func (b *BotContext) Member(groupID, userID int64) (MemberInfo, error) {
+	c := b.cache
+	c.lock.RLock()
+	var (
+		cm cachedMember
+		ok bool
+	)
+	if ml := c.members[groupID]; ml != nil {
+		cm, ok = ml.members[userID]
+	}
+	c.lock.RUnlock()
+	if ok && fresh(cm.at) {
+		return cm.info, nil
+	}
+	var mi MemberInfo
+	if err := b.callInfoApi("get_group_member_info", map[string]interface{}{"group_id": groupID, "user_id": userID, "no_cache": true}, &mi); err != nil {
+		return mi, err
+	}
+	c.lock.Lock()
+	c.memberList(groupID).members[userID] = cachedMember{info: mi, at: time.Now()}
+	c.lock.Unlock()
+	return mi, nil
+}
+
+// RefreshInfo drops the cache of the bot, so that everything is fetched again.
+func (b *BotContext) RefreshInfo() {
+	c := b.cache
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.friends, c.friendsAt = make(map[int64]FriendInfo), time.Time{}
+	c.groups, c.groupsAt = make(map[int64]GroupInfo), time.Time{}
+	c.groupAt = make(map[int64]time.Time)
+	c.members = make(map[int64]*memberList)
+}
+
+// memberList returns the members of the group, c.lock must be held.
+func (c *infoCache) memberList(groupID int64) *memberList {
+	ml := c.members[groupID]
+	if ml == nil {
+		ml = &memberList{members: make(map[int64]cachedMember)}
+		c.members[groupID] = ml
+	}
+	return ml
+}
+
+// update applies the event to the cache.
+func (c *infoCache) update(e *Event, bCtx *BotContext) {
+	switch e.PostType {
+	case MessageEvent:
+		if e.MessageType == MsgTypeGroup && e.GroupID != 0 && e.UserID != 0 {
+			c.updateSender(e)
+		}
+	case NoticeEvent:
+		c.updateByNotice(e, bCtx)
+	}
+}
+
+// updateSender updates the member by the sender of the group message,
+// join_time is kept.
+func (c *infoCache) updateSender(e *Event) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ml := c.memberList(e.GroupID)
+	cm, ok := ml.members[e.UserID]
+	mi := cm.info
+	mi.GroupID, mi.UserID = e.GroupID, e.UserID
+	mi.Nickname, mi.Card, mi.Role = e.Sender.Nickname, e.Sender.Card, e.Sender.Role
+	mi.Sex, mi.Age, mi.Area = e.Sender.Sex, e.Sender.Age, e.Sender.Area
+	mi.Level, mi.Title = e.Sender.Level, e.Sender.Title
+	mi.LastSentTime = int64(e.Time)
+	// a member made from a sender alone lacks join_time, so it is not fresh
+	if ok {
+		cm.at = time.Now()
+	}
+	ml.members[e.UserID] = cachedMember{info: mi, at: cm.at}
+}
+
+func (c *infoCache) updateByNotice(e *Event, bCtx *BotContext) {
+	self := e.UserID == bCtx.Info().BotID
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch e.NoticeType {
+	case NoticeGroupIncrease:
+		if self {
+			// the group is known at once, and its info is fetched in a new
+			// goroutine since the response is read by the same connection
+			if _, ok := c.groups[e.GroupID]; !ok {
+				c.groups[e.GroupID] = GroupInfo{GroupID: e.GroupID}
+			}
+			delete(c.groupAt, e.GroupID)
+			go func(groupID int64) {
+				if _, err := bCtx.Group(groupID); err != nil {
+					LBLogger.WithField("BotName", bCtx.Info().Name).Warnln(T("info.group-fail", groupID, err))
+				}
+			}(e.GroupID)
+			return
+		}
+		ml := c.memberList(e.GroupID)
+		ml.members[e.UserID] = cachedMember{info: MemberInfo{GroupID: e.GroupID, UserID: e.UserID, Role: RoleMember, JoinTime: int64(e.Time)}}
+		if gi, ok := c.groups[e.GroupID]; ok {
+			gi.MemberCount++
+			c.groups[e.GroupID] = gi
+		}
+	case NoticeGroupDecrease:
+		if self {
+			delete(c.groups, e.GroupID)
+			delete(c.groupAt, e.GroupID)
+			delete(c.members, e.GroupID)
+			return
+		}
+		if ml := c.members[e.GroupID]; ml != nil {
+			delete(ml.members, e.UserID)
+		}
+		if gi, ok := c.groups[e.GroupID]; ok && gi.MemberCount > 0 {
+			gi.MemberCount--
+			c.groups[e.GroupID] = gi
+		}
+	case NoticeGroupAdmin:
+		ml := c.memberList(e.GroupID)
+		if cm, ok := ml.members[e.UserID]; ok {
+			cm.info.Role = RoleMember
+			if e.SubType == "set" {
+				cm.info.Role = RoleAdmin
+			}
+			ml.members[e.UserID] = cm
+		}
+	case NoticeGroupCard:
+		ml := c.memberList(e.GroupID)
+		if cm, ok := ml.members[e.UserID]; ok {
+			cm.info.Card = e.CardNew
+			ml.members[e.UserID] = cm
+		}
+	case NoticeFriendAdd:
+		if _, ok := c.friends[e.UserID]; !ok {
+			c.friends[e.UserID] = FriendInfo{UserID: e.UserID}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package luxtbot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// roles of the sender of a group message
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Keyword matches if the plain text of the message contains one of the words.
+func Keyword(words ...string) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		text := e.GetPlainText()
+		for _, word := range words {
+			if strings.Contains(text, word) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// StartsWith matches if the plain text of the message starts with one of the prefixes.
+func StartsWith(prefixes ...string) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		text := strings.TrimSpace(e.GetPlainText())
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(text, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// EndsWith matches if the plain text of the message ends with one of the suffixes.
+func EndsWith(suffixes ...string) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		text := strings.TrimSpace(e.GetPlainText())
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(text, suffix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FullMatch matches if the plain text of the message is one of the texts.
+func FullMatch(texts ...string) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		text := strings.TrimSpace(e.GetPlainText())
+		for _, t := range texts {
+			if text == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Regex matches if the plain text of the message matches the pattern,
+// the match and the groups captured are put in Event.Matches.
+// It panics if the pattern could not be compiled.
+func Regex(pattern string) Judge {
+	j, err := RegexE(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("Regex: %v", err))
+	}
+	return j
+}
+
+// RegexE works like Regex, but returns the error instead of panicking.
+func RegexE(pattern string) (Judge, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return func(e *Event, bInfo BotInfo) bool {
+		matches := re.FindStringSubmatch(e.GetPlainText())
+		if matches == nil {
+			return false
+		}
+		e.Matches = matches
+		return true
+	}, nil
+}
+
+// ToMe matches private messages, messages at the bot,
+// and messages starting with the name of the bot or one of the nicknames.
+func ToMe(nicknames ...string) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		if e.MessageType == MsgTypePrivate || e.Message.HasAt(bInfo.BotID) {
+			return true
+		}
+		text := strings.TrimSpace(e.GetPlainText())
+		for _, name := range append([]string{bInfo.Name}, nicknames...) {
+			if name != "" && strings.HasPrefix(text, name) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// InGroups matches events of the groups.
+func InGroups(groupIDs ...int64) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		return e.GroupID != 0 && containsID(groupIDs, e.GroupID)
+	}
+}
+
+// NotInGroups matches events not of the groups, including private ones.
+func NotInGroups(groupIDs ...int64) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		return !containsID(groupIDs, e.GroupID)
+	}
+}
+
+// FromUsers matches events of the users.
+func FromUsers(userIDs ...int64) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		return containsID(userIDs, e.UserID)
+	}
+}
+
+// NotFromUsers matches events not of the users.
+func NotFromUsers(userIDs ...int64) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		return !containsID(userIDs, e.UserID)
+	}
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// SenderRole matches group messages sent by one of the roles:
+// RoleOwner, RoleAdmin or RoleMember.
+func SenderRole(roles ...string) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		for _, role := range roles {
+			if e.Sender.Role == role {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SubType matches events with one of the sub types.
+func SubType(subTypes ...string) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		for _, subType := range subTypes {
+			if e.SubType == subType {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// TimeBetween matches events received between start and end in local time,
+// written as "15:04". The window crosses midnight if end is before start.
+// It panics if start or end could not be parsed.
+func TimeBetween(start, end string) Judge {
+	j, err := TimeBetweenE(start, end)
+	if err != nil {
+		panic(fmt.Sprintf("TimeBetween: %v", err))
+	}
+	return j
+}
+
+// TimeBetweenE works like TimeBetween, but returns the error instead of panicking.
+func TimeBetweenE(start, end string) (Judge, error) {
+	from, err := parseClock(start)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseClock(end)
+	if err != nil {
+		return nil, err
+	}
+	return func(e *Event, bInfo BotInfo) bool {
+		now := time.Now()
+		clock := now.Hour()*60 + now.Minute()
+		if from <= to {
+			return clock >= from && clock < to
+		}
+		return clock >= from || clock < to
+	}, nil
+}
+
+// parseClock returns the minutes from midnight of "15:04".
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -30,36 +30,35 @@ type LogConf struct {
 }
 
 func InitLogConf() {
-	var (
-		level logrus.Level
-		err   error
-	)
-
 	formatter := &Formatter{
 		TimestampFormat: "2006/01/02-15:04:05",
 		LineFormat:      "[%lvl%] %fn%-%fln% | %time%: %msg% --- ",
 	}
 	logConf := &Conf.LogConf
-	if logConf.MaxFiles <= 0 {
-		fmt.Println("Log Config max files is Worong. Use default config: ", DefaultMaxFiles)
-		logConf.MaxFiles = DefaultMaxFiles
-	}
 	writers := io.MultiWriter(os.Stdout, rotateWriter(DefaultFileNameFmt, logConf.MaxFiles, DefaultRotateDura))
 	LBLogger = logrus.Logger{}
 
 	LBLogger.SetOutput(writers)
 	LBLogger.SetReportCaller(true)
 	LBLogger.SetFormatter(formatter)
-	level, err = logrus.ParseLevel(Conf.LogConf.Level)
+	err := SetLogLevel(Conf.LogConf.Level)
 	if err != nil {
+		LBLogger.SetLevel(DefaultLevel)
 		LBLogger.Warnln("Log Config level is Wrong, use default log level: WARN")
-		level = DefaultLevel
-	} else {
-		LBLogger.SetLevel(level)
-		LBLogger.Infoln("Use Log Level: ", Conf.LogConf.Level)
 	}
 }
 
+// SetLogLevel changes the level of LBLogger at runtime.
+func SetLogLevel(levelStr string) error {
+	level, err := logrus.ParseLevel(levelStr)
+	if err != nil {
+		return err
+	}
+	LBLogger.SetLevel(level)
+	LBLogger.Infoln("Use Log Level: ", levelStr)
+	return nil
+}
+
 /**
  * @description: logrus formatter
  *   %msg% - messsage, %lvl% - level, %time% - time, %fn% - filename, %fln% - file line number
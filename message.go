@@ -4,8 +4,6 @@ import (
 	"bytes"
 	"errors"
 	"regexp"
-	"strconv"
-	"strings"
 )
 
 const (
@@ -44,6 +42,8 @@ type MsgBuilder interface {
 	GetMsg() (interface{}, error)
 }
 
+// TextMsg builds a string message, text is escaped and
+// the other segments are encoded as CQ codes.
 type TextMsg struct {
 	buf bytes.Buffer
 }
@@ -52,67 +52,136 @@ func MakeTextMsg() *TextMsg {
 	return &TextMsg{}
 }
 
+// AddSeg encodes a segment and appends it to the message.
+func (tm *TextMsg) AddSeg(seg MsgSeg) *TextMsg {
+	tm.buf.WriteString(EncodeMsgSeg(seg))
+	return tm
+}
+
 func (tm *TextMsg) NewLine() *TextMsg {
 	tm.buf.WriteByte('\n')
 	return tm
 }
 
 func (tm *TextMsg) AddText(text string) *TextMsg {
-	tm.buf.WriteString(text)
-	return tm
+	return tm.AddSeg(textSeg(text))
 }
 
-func (tm *TextMsg) AddImg(file, url string) *TextMsg {
-	tm.buf.WriteString("[CQ:image,file='")
-	tm.buf.WriteString(file)
-	tm.buf.WriteString("']")
-	return tm
+// AddImg adds an image, with the options ImgFlash, ImgShow, SegCache, SegProxy, SegTimeout and SegThreads.
+func (tm *TextMsg) AddImg(file, url string, opts ...SegOption) *TextMsg {
+	return tm.AddSeg(fileSeg(ImageMsgSeg, file, url, opts...))
 }
 
-func (tm *TextMsg) AddFace(faceNo int) *TextMsg {
-	tm.buf.WriteString("[CQ:face,id=")
-	tm.buf.WriteString(strconv.Itoa(faceNo))
-	tm.buf.WriteByte(']')
+func (tm *TextMsg) AddFace(faceID int) *TextMsg {
+	return tm.AddSeg(faceSeg(faceID))
+}
 
-	return tm
+// AddAt adds an at, AtAll for at all members.
+func (tm *TextMsg) AddAt(uid int64, opts ...SegOption) *TextMsg {
+	return tm.AddSeg(atSeg(uid, opts...))
 }
 
-func (tm *TextMsg) AddAt(uid int64) *TextMsg {
-	tm.buf.WriteString("[CQ:at,qq=")
-	if uid == AtAll {
-		tm.buf.WriteString("all")
-	} else {
-		tm.buf.WriteString(strconv.FormatInt(uid, 10))
-	}
-	tm.buf.WriteByte(']')
-	return tm
+// AddRecord adds a record, with the options RecordMagic, SegCache, SegProxy and SegTimeout.
+func (tm *TextMsg) AddRecord(file, url string, opts ...SegOption) *TextMsg {
+	return tm.AddSeg(fileSeg(RecordMsgSeg, file, url, opts...))
 }
 
-func (tm *TextMsg) AddRecord(file, url string) *TextMsg {
-	tm.buf.WriteString("[CQ:record,file='")
-	tm.buf.WriteString(file)
-	tm.buf.WriteString("']")
-	return tm
+// AddVideo adds a video, with the options VideoCover and SegThreads.
+func (tm *TextMsg) AddVideo(file, url string, opts ...SegOption) *TextMsg {
+	return tm.AddSeg(fileSeg(VideoMsgSeg, file, url, opts...))
 }
 
 func (tm *TextMsg) AddRPS() *TextMsg {
-	tm.buf.WriteString("[CQ:rps]")
-	return tm
+	return tm.AddSeg(newSeg(RPSMsgSeg))
 }
 
 func (tm *TextMsg) AddDice() *TextMsg {
-	tm.buf.WriteString("[CQ:dice]")
-	return tm
+	return tm.AddSeg(newSeg(DiceMsgSeg))
 }
 
 func (tm *TextMsg) AddShake() *TextMsg {
-	tm.buf.WriteString("[CQ:shake]")
-	return tm
+	return tm.AddSeg(newSeg(ShakeMsgSeg))
+}
+
+func (tm *TextMsg) AddPoke(qq int64) *TextMsg {
+	return tm.AddSeg(pokeSeg(qq))
+}
+
+// AddAnonymous sends the message anonymously, if ignore is true, it is sent as usual when anonymity is unavailable.
+func (tm *TextMsg) AddAnonymous(ignore bool) *TextMsg {
+	return tm.AddSeg(anonymousSeg(ignore))
+}
+
+func (tm *TextMsg) AddShare(url, title, content, image string) *TextMsg {
+	return tm.AddSeg(shareSeg(url, title, content, image))
+}
+
+// AddContact recommends a friend or group, contactType is ContactQQ or ContactGroup.
+func (tm *TextMsg) AddContact(contactType string, id int64) *TextMsg {
+	return tm.AddSeg(contactSeg(contactType, id))
+}
+
+func (tm *TextMsg) AddLocation(lat, lon float64, title, content string) *TextMsg {
+	return tm.AddSeg(locationSeg(lat, lon, title, content))
+}
+
+// AddMusic shares a music, musicType is MusicQQ, Music163 or MusicXM.
+func (tm *TextMsg) AddMusic(musicType, id string) *TextMsg {
+	return tm.AddSeg(musicSeg(musicType, id))
+}
+
+func (tm *TextMsg) AddCustomMusic(url, audio, title, content, image string) *TextMsg {
+	return tm.AddSeg(customMusicSeg(url, audio, title, content, image))
+}
+
+// AddReply quotes a message, it should be the first segment.
+func (tm *TextMsg) AddReply(msgID int) *TextMsg {
+	return tm.AddSeg(replySeg(msgID))
+}
+
+// AddCustomReply quotes a made up message.
+func (tm *TextMsg) AddCustomReply(text string, qq, time int64, seq int) *TextMsg {
+	return tm.AddSeg(customReplySeg(text, qq, time, seq))
+}
+
+func (tm *TextMsg) AddForward(id string) *TextMsg {
+	return tm.AddSeg(forwardSeg(id))
+}
+
+// AddNode adds an existing message to a merged forward message.
+func (tm *TextMsg) AddNode(msgID int) *TextMsg {
+	return tm.AddSeg(nodeSeg(msgID))
+}
+
+// AddCustomNode adds a made up message to a merged forward message, content is a string message.
+func (tm *TextMsg) AddCustomNode(name string, uin int64, content string) *TextMsg {
+	return tm.AddSeg(customNodeSeg(name, uin, content))
+}
+
+func (tm *TextMsg) AddXml(data string, opts ...SegOption) *TextMsg {
+	return tm.AddSeg(dataSeg(XmlMsgSeg, data, opts...))
+}
+
+func (tm *TextMsg) AddJson(data string, opts ...SegOption) *TextMsg {
+	return tm.AddSeg(dataSeg(JsonMsgSeg, data, opts...))
+}
+
+func (tm *TextMsg) AddTTS(text string) *TextMsg {
+	return tm.AddSeg(ttsSeg(text))
+}
+
+// AddCardImage adds a card image, with the options CardImageSize and CardImageSource.
+func (tm *TextMsg) AddCardImage(file string, opts ...SegOption) *TextMsg {
+	return tm.AddSeg(cardImageSeg(file, opts...))
+}
+
+func (tm *TextMsg) AddGift(qq int64, giftID int) *TextMsg {
+	return tm.AddSeg(giftSeg(qq, giftID))
 }
 
 func (tm *TextMsg) GetMsg() (interface{}, error) {
 	if tm.buf.Len() == 0 {
-		return "", errors.New("消息为空。")
+		return "", ErrEmptyMessage
 	}
 	return tm.buf.String(), nil
 }
@@ -123,171 +192,156 @@ type MsgSeg struct {
 }
 
 type ArrayMsg struct {
-	Segs []MsgSeg
+	Segs Message
 	Len  int
 }
 
 func MakeArrayMsg(size int) *ArrayMsg {
 	msg := ArrayMsg{
-		Segs: make([]MsgSeg, 0, size),
+		Segs: make(Message, 0, size),
 		Len:  0,
 	}
 	return &msg
 }
 
-func (am *ArrayMsg) initNewSeg(segType string) map[string]string {
-	data := make(map[string]string)
-	mSeg := MsgSeg{
-		Type: segType,
-		Data: data,
-	}
-	am.Segs = append(am.Segs, mSeg)
+// AddSeg appends a segment to the message.
+func (am *ArrayMsg) AddSeg(seg MsgSeg) *ArrayMsg {
+	am.Segs = append(am.Segs, seg)
 	am.Len++
-	return am.Segs[len(am.Segs)-1].Data
+	return am
 }
 
 func (am *ArrayMsg) AddText(text string) *ArrayMsg {
-	data := am.initNewSeg(TextMsgSeg)
-	data["text"] = text
-	return am
+	return am.AddSeg(textSeg(text))
 }
 
-func (am *ArrayMsg) AddImg(file, url string) *ArrayMsg {
-	data := am.initNewSeg(ImageMsgSeg)
-	data["file"] = file
-	data["url"] = url
-	return am
+// AddImg adds an image, with the options ImgFlash, ImgShow, SegCache, SegProxy, SegTimeout and SegThreads.
+func (am *ArrayMsg) AddImg(file, url string, opts ...SegOption) *ArrayMsg {
+	return am.AddSeg(fileSeg(ImageMsgSeg, file, url, opts...))
 }
 
 func (am *ArrayMsg) AddFace(faceID int) *ArrayMsg {
-	data := am.initNewSeg(FaceMsgSeg)
-	data["id"] = strconv.Itoa(faceID)
-	return am
+	return am.AddSeg(faceSeg(faceID))
 }
 
-func (am *ArrayMsg) AddAt(uid int64) *ArrayMsg {
-	data := am.initNewSeg(AtMsgSeg)
-	if uid == AtAll {
-		data["qq"] = "all"
-	} else {
-		data["qq"] = strconv.FormatInt(uid, 10)
-	}
-	return am
+// AddAt adds an at, AtAll for at all members.
+func (am *ArrayMsg) AddAt(uid int64, opts ...SegOption) *ArrayMsg {
+	return am.AddSeg(atSeg(uid, opts...))
 }
 
-func (am *ArrayMsg) AddRecord(file, url string) *ArrayMsg {
-	data := am.initNewSeg(RecordMsgSeg)
-	data["file"] = file
-	data["url"] = url
-	return am
+// AddRecord adds a record, with the options RecordMagic, SegCache, SegProxy and SegTimeout.
+func (am *ArrayMsg) AddRecord(file, url string, opts ...SegOption) *ArrayMsg {
+	return am.AddSeg(fileSeg(RecordMsgSeg, file, url, opts...))
+}
+
+// AddVideo adds a video, with the options VideoCover and SegThreads.
+func (am *ArrayMsg) AddVideo(file, url string, opts ...SegOption) *ArrayMsg {
+	return am.AddSeg(fileSeg(VideoMsgSeg, file, url, opts...))
 }
 
 func (am *ArrayMsg) AddRPS() *ArrayMsg {
-	am.initNewSeg(RPSMsgSeg)
-	return am
+	return am.AddSeg(newSeg(RPSMsgSeg))
 }
 
 func (am *ArrayMsg) AddDice() *ArrayMsg {
-	am.initNewSeg(DiceMsgSeg)
-	return am
+	return am.AddSeg(newSeg(DiceMsgSeg))
 }
 
 func (am *ArrayMsg) AddShake() *ArrayMsg {
-	am.initNewSeg(ShakeMsgSeg)
-	return am
+	return am.AddSeg(newSeg(ShakeMsgSeg))
 }
 
-func (am *ArrayMsg) GetMsg() (interface{}, error) {
-	if am.Len != len(am.Segs) {
-		return nil, errors.New("消息段数与预期不符，检查创建方式是否正确")
-	}
-	return am.Segs, nil
+func (am *ArrayMsg) AddPoke(qq int64) *ArrayMsg {
+	return am.AddSeg(pokeSeg(qq))
 }
 
-func ParseTextMsg(segs []MsgSeg) string {
-	tm := MakeTextMsg()
-	for _, seg := range segs {
-		switch seg.Type {
-		case TextMsgSeg:
-			tm.AddText(seg.Data["text"])
-		case FaceMsgSeg:
-			id, err := strconv.Atoi(seg.Data["id"])
-			if err != nil {
-				LBLogger.WithField("face id", seg.Data["id"]).Debugln("表情ID格式错误")
-				break
-			}
-			tm.AddFace(id)
-		case ImageMsgSeg:
-			tm.AddImg(seg.Data["file"], seg.Data["url"])
-		case RecordMsgSeg:
-			tm.AddRecord(seg.Data["file"], seg.Data["url"])
-		case AtMsgSeg:
-			qqStr := seg.Data["qq"]
-			if qqStr == "all" {
-				tm.AddAt(AtAll)
-			}
-			qq, err := strconv.ParseInt(qqStr, 10, 64)
-			if err != nil {
-				LBLogger.WithField("QQ", qqStr).Debugln("AT QQ号错误")
-				break
-			}
-			tm.AddAt(qq)
-		case RPSMsgSeg:
-			tm.AddRPS()
-		case DiceMsgSeg:
-			tm.AddDice()
-		case ShakeMsgSeg:
-			tm.AddShake()
-		}
-	}
-	return tm.buf.String()
+// AddAnonymous sends the message anonymously, if ignore is true, it is sent as usual when anonymity is unavailable.
+func (am *ArrayMsg) AddAnonymous(ignore bool) *ArrayMsg {
+	return am.AddSeg(anonymousSeg(ignore))
 }
 
-var SegRegex = regexp.MustCompile(`\[CQ:[a-z]{2,9}[^\]]*\]`)
+func (am *ArrayMsg) AddShare(url, title, content, image string) *ArrayMsg {
+	return am.AddSeg(shareSeg(url, title, content, image))
+}
 
-func ParseMsgSegs(msg string) []MsgSeg {
-	re := SegRegex.FindAllStringIndex(msg, 10)
-	segs := make([]MsgSeg, 0, 5)
-	l := 0
-	for _, part := range re {
-		if part[0] != l {
-			seg := parseMsgSeg(msg[l:part[0]])
-			segs = append(segs, seg)
-		}
-		seg := parseMsgSeg(msg[part[0]:part[1]])
-		segs = append(segs, seg)
-		l = part[1]
-	}
-	if l <= len(msg)-1 {
-		seg := parseMsgSeg(msg[l:])
-		segs = append(segs, seg)
-	}
-	return segs
+// AddContact recommends a friend or group, contactType is ContactQQ or ContactGroup.
+func (am *ArrayMsg) AddContact(contactType string, id int64) *ArrayMsg {
+	return am.AddSeg(contactSeg(contactType, id))
 }
 
-func parseMsgSeg(segStr string) MsgSeg {
-	if segStr[0] != '[' {
-		data := make(map[string]string)
-		data["text"] = segStr
-		return MsgSeg{
-			Type: TextMsgSeg,
-			Data: data,
-		}
-	}
-	strs := strings.Split(segStr, ",")
-	seg := MsgSeg{
-		Data: make(map[string]string),
-	}
-	if len(strs) == 1 {
-		seg.Type = strs[0][4 : len(strs[0])-1]
-	} else {
-		seg.Type = strs[0][4:len(strs[0])]
-		pair := strings.Split(strs[len(strs)-1], "=")
-		seg.Data[pair[0]] = pair[1][:len(pair[1])-1]
+func (am *ArrayMsg) AddLocation(lat, lon float64, title, content string) *ArrayMsg {
+	return am.AddSeg(locationSeg(lat, lon, title, content))
+}
+
+// AddMusic shares a music, musicType is MusicQQ, Music163 or MusicXM.
+func (am *ArrayMsg) AddMusic(musicType, id string) *ArrayMsg {
+	return am.AddSeg(musicSeg(musicType, id))
+}
+
+func (am *ArrayMsg) AddCustomMusic(url, audio, title, content, image string) *ArrayMsg {
+	return am.AddSeg(customMusicSeg(url, audio, title, content, image))
+}
+
+// AddReply quotes a message, it should be the first segment.
+func (am *ArrayMsg) AddReply(msgID int) *ArrayMsg {
+	return am.AddSeg(replySeg(msgID))
+}
+
+// AddCustomReply quotes a made up message.
+func (am *ArrayMsg) AddCustomReply(text string, qq, time int64, seq int) *ArrayMsg {
+	return am.AddSeg(customReplySeg(text, qq, time, seq))
+}
+
+func (am *ArrayMsg) AddForward(id string) *ArrayMsg {
+	return am.AddSeg(forwardSeg(id))
+}
+
+// AddNode adds an existing message to a merged forward message.
+func (am *ArrayMsg) AddNode(msgID int) *ArrayMsg {
+	return am.AddSeg(nodeSeg(msgID))
+}
+
+// AddCustomNode adds a made up message to a merged forward message, content is a string message.
+func (am *ArrayMsg) AddCustomNode(name string, uin int64, content string) *ArrayMsg {
+	return am.AddSeg(customNodeSeg(name, uin, content))
+}
+
+func (am *ArrayMsg) AddXml(data string, opts ...SegOption) *ArrayMsg {
+	return am.AddSeg(dataSeg(XmlMsgSeg, data, opts...))
+}
+
+func (am *ArrayMsg) AddJson(data string, opts ...SegOption) *ArrayMsg {
+	return am.AddSeg(dataSeg(JsonMsgSeg, data, opts...))
+}
+
+func (am *ArrayMsg) AddTTS(text string) *ArrayMsg {
+	return am.AddSeg(ttsSeg(text))
+}
+
+// AddCardImage adds a card image, with the options CardImageSize and CardImageSource.
+func (am *ArrayMsg) AddCardImage(file string, opts ...SegOption) *ArrayMsg {
+	return am.AddSeg(cardImageSeg(file, opts...))
+}
+
+func (am *ArrayMsg) AddGift(qq int64, giftID int) *ArrayMsg {
+	return am.AddSeg(giftSeg(qq, giftID))
+}
+
+func (am *ArrayMsg) GetMsg() (interface{}, error) {
+	if am.Len != len(am.Segs) {
+		return nil, errors.New(T("err.seg-count"))
 	}
-	for i := 1; i < len(strs)-1; i++ {
-		pair := strings.Split(strs[1], "=")
-		seg.Data[pair[0]] = pair[1]
+	if am.Len == 0 {
+		return nil, ErrEmptyMessage
 	}
-	return seg
+	return am.Segs, nil
+}
+
+// ParseTextMsg encodes segments to a string message, every segment
+// type is kept as a CQ code.
+func ParseTextMsg(segs []MsgSeg) string {
+	return EncodeMsgSegs(segs)
 }
+
+// Deprecated: CQ codes are parsed by ParseMsgSegs without regexp now.
+var SegRegex = regexp.MustCompile(`\[CQ:[a-z]{2,9}[^\]]*\]`)
@@ -0,0 +1,311 @@
+package luxtbot
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Hooks are registered by name with a priority, the smaller priority runs
+// first, and the same priority runs in the order of registering.
+// Registering a name again replaces the old hook, and RemoveHook
+// unregisters the hooks of the name of all hook points.
+//
+// Middlewares of event-in and api-out wrap each other in this order,
+// calling next runs the rest of the chain, and returning without calling
+// it aborts the event or the api. The code after next is the after phase.
+//
+// The legacy hook chains run as one middleware of LegacyHookPriority.
+const (
+	PriorityFirst      = -1000
+	LegacyHookPriority = 0
+	PriorityLast       = 1000
+
+	blacklistHookName = "luxtbot.blacklist"
+	legacyHookName    = "luxtbot.legacy"
+)
+
+// EventInCtx is what an event-in middleware gets.
+type EventInCtx struct {
+	Event *Event
+	Bot   *BotContext
+}
+
+// BotInfo returns the info of the bot receiving the event.
+func (c *EventInCtx) BotInfo() BotInfo {
+	return *c.Bot.Info()
+}
+
+// ApiOutCtx is what an api-out middleware gets, Api could be modified
+// before calling next. Params of the api of sending messages is a pointer,
+// like *GroupMsg. An over-long message is split after the middlewares,
+// so they get the whole message.
+type ApiOutCtx struct {
+	Api *ApiPost
+	Bot *BotContext
+}
+
+// BotInfo returns the info of the bot sending the api.
+func (c *ApiOutCtx) BotInfo() BotInfo {
+	return *c.Bot.Info()
+}
+
+type EventInMiddleware func(c *EventInCtx, next func() error) error
+
+type ApiOutMiddleware func(c *ApiOutCtx, next func() error) error
+
+// ApiRespHook is called for every api response received, before the echo callback.
+type ApiRespHook func(resp *ApiResp, bInfo BotInfo)
+
+// BeforeUnitHook is called before a unit processes the event,
+// the unit is skipped if it returns an error.
+type BeforeUnitHook func(c *Ctx) error
+
+// AfterUnitHook is called after a unit processed the event, even if it panicked.
+type AfterUnitHook func(c *Ctx, elapsed time.Duration)
+
+// UnitErrorHook is called when a unit panics, with a *UnitPanic,
+// or runs over its timeout, with ErrUnitTimeout.
+type UnitErrorHook func(c *Ctx, err error)
+
+type BotState string
+
+const (
+	BotStarted BotState = "started"
+	BotOnline  BotState = "online"
+	BotOffline BotState = "offline"
+	BotStopped BotState = "stopped"
+)
+
+// BotStateHook is called when a bot is started, online, offline or stopped.
+type BotStateHook func(bInfo BotInfo, state BotState)
+
+type hookEntry struct {
+	name     string
+	priority int
+	seq      int
+	fn       interface{}
+}
+
+// hookList is a list of hooks sorted by priority.
+type hookList struct {
+	sync.RWMutex
+	entries []hookEntry
+}
+
+var (
+	hookSeq  int
+	hookLock sync.Mutex
+
+	eventInMws  hookList
+	apiOutMws   hookList
+	apiRespHks  hookList
+	beforeUnits hookList
+	afterUnits  hookList
+	unitErrHks  hookList
+	botStateHks hookList
+
+	allHookLists = []*hookList{&eventInMws, &apiOutMws, &apiRespHks, &beforeUnits, &afterUnits, &unitErrHks, &botStateHks}
+)
+
+func init() {
+	UseEventIn(blacklistHookName, PriorityFirst, func(c *EventInCtx, next func() error) error {
+		if err := blacklistHook(c.Event, c.BotInfo()); err != nil {
+			return err
+		}
+		return next()
+	})
+	UseEventIn(legacyHookName, LegacyHookPriority, func(c *EventInCtx, next func() error) error {
+		for _, hook := range eventInChain {
+			if err := hook(c.Event, c.BotInfo()); err != nil {
+				return err
+			}
+		}
+		return next()
+	})
+	UseApiOut(legacyHookName, LegacyHookPriority, func(c *ApiOutCtx, next func() error) error {
+		for _, hook := range beforeApiOutChain {
+			if err := hook(c.Api, c.BotInfo()); err != nil {
+				return err
+			}
+		}
+		return next()
+	})
+}
+
+func (hl *hookList) add(name string, priority int, fn interface{}) {
+	hookLock.Lock()
+	hookSeq++
+	seq := hookSeq
+	hookLock.Unlock()
+	hl.Lock()
+	defer hl.Unlock()
+	hl.removeLocked(name)
+	hl.entries = append(hl.entries, hookEntry{name: name, priority: priority, seq: seq, fn: fn})
+	sort.Slice(hl.entries, func(i, j int) bool {
+		a, b := hl.entries[i], hl.entries[j]
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		return a.seq < b.seq
+	})
+}
+
+func (hl *hookList) removeLocked(name string) bool {
+	for i := range hl.entries {
+		if hl.entries[i].name == name {
+			hl.entries = append(hl.entries[:i:i], hl.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (hl *hookList) remove(name string) bool {
+	hl.Lock()
+	defer hl.Unlock()
+	return hl.removeLocked(name)
+}
+
+// fns returns a snapshot of the hooks, so that they could be changed while running.
+func (hl *hookList) fns() []interface{} {
+	hl.RLock()
+	defer hl.RUnlock()
+	fns := make([]interface{}, len(hl.entries))
+	for i := range hl.entries {
+		fns[i] = hl.entries[i].fn
+	}
+	return fns
+}
+
+func UseEventIn(name string, priority int, mw EventInMiddleware) {
+	eventInMws.add(name, priority, mw)
+}
+
+func UseApiOut(name string, priority int, mw ApiOutMiddleware) {
+	apiOutMws.add(name, priority, mw)
+}
+
+func OnApiResp(name string, priority int, hook ApiRespHook) {
+	apiRespHks.add(name, priority, hook)
+}
+
+func OnBeforeUnit(name string, priority int, hook BeforeUnitHook) {
+	beforeUnits.add(name, priority, hook)
+}
+
+func OnAfterUnit(name string, priority int, hook AfterUnitHook) {
+	afterUnits.add(name, priority, hook)
+}
+
+func OnUnitError(name string, priority int, hook UnitErrorHook) {
+	unitErrHks.add(name, priority, hook)
+}
+
+func OnBotState(name string, priority int, hook BotStateHook) {
+	botStateHks.add(name, priority, hook)
+}
+
+// RemoveHook unregisters the hooks of the name, false if there is none.
+func RemoveHook(name string) bool {
+	removed := false
+	for _, hl := range allHookLists {
+		if hl.remove(name) {
+			removed = true
+		}
+	}
+	return removed
+}
+
+// ErrHookPanic is returned by the middleware chains and the before-unit
+// hooks when one of them panics, the panic is logged.
+const ErrHookPanic Error = "err.hook-panic"
+
+// recoverHook logs a panic of the hooks of the point, and makes it err if
+// err is not nil, so that a hook never brings down the goroutine running it.
+func recoverHook(point string, err *error) {
+	if r := recover(); r != nil {
+		LBLogger.WithField("Hook", point).Errorf("%v\n%s", T("exec.hook-panic", point, r), debug.Stack())
+		if err != nil {
+			*err = fmt.Errorf("%w: %v", ErrHookPanic, r)
+		}
+	}
+}
+
+// runEventIn runs the event-in middlewares, a nil error means the event passes.
+func runEventIn(e *Event, bCtx *BotContext) (err error) {
+	defer recoverHook("event-in", &err)
+	mws, c := eventInMws.fns(), &EventInCtx{Event: e, Bot: bCtx}
+	var next func(i int) error
+	next = func(i int) error {
+		if i == len(mws) {
+			return nil
+		}
+		return mws[i].(EventInMiddleware)(c, func() error { return next(i + 1) })
+	}
+	return next(0)
+}
+
+// runApiOut runs the api-out middlewares with last as the last one.
+func runApiOut(api *ApiPost, bCtx *BotContext, last func() error) (err error) {
+	defer recoverHook("api-out", &err)
+	mws, c := apiOutMws.fns(), &ApiOutCtx{Api: api, Bot: bCtx}
+	var next func(i int) error
+	next = func(i int) error {
+		if i == len(mws) {
+			return last()
+		}
+		return mws[i].(ApiOutMiddleware)(c, func() error { return next(i + 1) })
+	}
+	return next(0)
+}
+
+// the hooks below run one by one, a panic of one does not stop the rest.
+
+func runApiRespHooks(resp *ApiResp, bInfo BotInfo) {
+	for _, fn := range apiRespHks.fns() {
+		func() {
+			defer recoverHook("api-resp", nil)
+			fn.(ApiRespHook)(resp, bInfo)
+		}()
+	}
+}
+
+func runBeforeUnitHooks(c *Ctx) (err error) {
+	defer recoverHook("before-unit", &err)
+	for _, fn := range beforeUnits.fns() {
+		if err := fn.(BeforeUnitHook)(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runAfterUnitHooks(c *Ctx, elapsed time.Duration) {
+	for _, fn := range afterUnits.fns() {
+		func() {
+			defer recoverHook("after-unit", nil)
+			fn.(AfterUnitHook)(c, elapsed)
+		}()
+	}
+}
+
+func runUnitErrorHooks(c *Ctx, err error) {
+	for _, fn := range unitErrHks.fns() {
+		func() {
+			defer recoverHook("unit-error", nil)
+			fn.(UnitErrorHook)(c, err)
+		}()
+	}
+}
+
+func runBotStateHooks(bInfo BotInfo, state BotState) {
+	for _, fn := range botStateHks.fns() {
+		func() {
+			defer recoverHook("bot-state", nil)
+			fn.(BotStateHook)(bInfo, state)
+		}()
+	}
+}
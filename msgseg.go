@@ -0,0 +1,288 @@
+package luxtbot
+
+import (
+	"strconv"
+)
+
+// segments extended by go-cqhttp
+const (
+	TTSMsgSeg       = "tts"
+	CardImageMsgSeg = "cardimage"
+	GiftMsgSeg      = "gift"
+)
+
+const (
+	ImgTypeFlash = "flash"
+	ImgTypeShow  = "show"
+
+	ContactQQ    = "qq"
+	ContactGroup = "group"
+
+	MusicQQ     = "qq"
+	Music163    = "163"
+	MusicXM     = "xm"
+	MusicCustom = "custom"
+)
+
+// SegOption sets an optional parameter of a segment.
+type SegOption func(seg MsgSeg)
+
+// SegParam sets any parameter of a segment.
+func SegParam(key, value string) SegOption {
+	return func(seg MsgSeg) {
+		seg.Data[key] = value
+	}
+}
+
+// ImgFlash makes an image a flash image.
+func ImgFlash() SegOption {
+	return SegParam("type", ImgTypeFlash)
+}
+
+// ImgShow makes an image a show image with an effect id in 40000-40005.
+func ImgShow(effectID int) SegOption {
+	return func(seg MsgSeg) {
+		seg.Data["type"] = ImgTypeShow
+		seg.Data["id"] = strconv.Itoa(effectID)
+	}
+}
+
+// SegCache sets whether to use the cached file of an image, record or video url.
+func SegCache(on bool) SegOption {
+	return SegParam("cache", boolParam(on))
+}
+
+// SegProxy sets whether to download the url of an image, record or video by proxy.
+func SegProxy(on bool) SegOption {
+	return SegParam("proxy", boolParam(on))
+}
+
+// SegTimeout sets the timeout in seconds of downloading the url.
+func SegTimeout(seconds int) SegOption {
+	return SegParam("timeout", strconv.Itoa(seconds))
+}
+
+// SegThreads sets the thread count of downloading the url.
+func SegThreads(c int) SegOption {
+	return SegParam("c", strconv.Itoa(c))
+}
+
+// RecordMagic makes a record a voice changed record.
+func RecordMagic() SegOption {
+	return SegParam("magic", "1")
+}
+
+// VideoCover sets the cover image of a video.
+func VideoCover(cover string) SegOption {
+	return SegParam("cover", cover)
+}
+
+// AtName sets the name shown when the at user is not in the group.
+func AtName(name string) SegOption {
+	return SegParam("name", name)
+}
+
+// SegResID sets the resid of a xml or json segment.
+func SegResID(resid int) SegOption {
+	return SegParam("resid", strconv.Itoa(resid))
+}
+
+// CardImageSize sets the size limit of a card image, 0 means default.
+func CardImageSize(minWidth, minHeight, maxWidth, maxHeight int) SegOption {
+	return func(seg MsgSeg) {
+		for k, v := range map[string]int{"minwidth": minWidth, "minheight": minHeight, "maxwidth": maxWidth, "maxheight": maxHeight} {
+			if v > 0 {
+				seg.Data[k] = strconv.Itoa(v)
+			}
+		}
+	}
+}
+
+// CardImageSource sets the source name and icon of a card image.
+func CardImageSource(source, icon string) SegOption {
+	return func(seg MsgSeg) {
+		seg.Data["source"] = source
+		seg.Data["icon"] = icon
+	}
+}
+
+func boolParam(on bool) string {
+	if on {
+		return "1"
+	}
+	return "0"
+}
+
+func newSeg(segType string, opts ...SegOption) MsgSeg {
+	seg := MsgSeg{
+		Type: segType,
+		Data: make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(seg)
+	}
+	return seg
+}
+
+func textSeg(text string) MsgSeg {
+	return newSeg(TextMsgSeg, SegParam("text", text))
+}
+
+// fileSeg makes image, record or video segments
+func fileSeg(segType, file, url string, opts ...SegOption) MsgSeg {
+	seg := newSeg(segType, SegParam("file", file))
+	if url != "" {
+		seg.Data["url"] = url
+	}
+	for _, opt := range opts {
+		opt(seg)
+	}
+	return seg
+}
+
+func faceSeg(faceID int) MsgSeg {
+	return newSeg(FaceMsgSeg, SegParam("id", strconv.Itoa(faceID)))
+}
+
+func atSeg(uid int64, opts ...SegOption) MsgSeg {
+	qq := strconv.FormatInt(uid, 10)
+	if uid == AtAll {
+		qq = "all"
+	}
+	return newSeg(AtMsgSeg, append([]SegOption{SegParam("qq", qq)}, opts...)...)
+}
+
+func pokeSeg(qq int64) MsgSeg {
+	return newSeg(PokeMsgSeg, SegParam("qq", strconv.FormatInt(qq, 10)))
+}
+
+func anonymousSeg(ignore bool) MsgSeg {
+	return newSeg(AnonymousMsgSeg, SegParam("ignore", boolParam(ignore)))
+}
+
+func shareSeg(url, title, content, image string) MsgSeg {
+	seg := newSeg(ShareMsgSeg, SegParam("url", url), SegParam("title", title))
+	if content != "" {
+		seg.Data["content"] = content
+	}
+	if image != "" {
+		seg.Data["image"] = image
+	}
+	return seg
+}
+
+func contactSeg(contactType string, id int64) MsgSeg {
+	return newSeg(ContactMsgSeg, SegParam("type", contactType), SegParam("id", strconv.FormatInt(id, 10)))
+}
+
+func locationSeg(lat, lon float64, title, content string) MsgSeg {
+	seg := newSeg(LocationMsgSeg,
+		SegParam("lat", strconv.FormatFloat(lat, 'f', -1, 64)),
+		SegParam("lon", strconv.FormatFloat(lon, 'f', -1, 64)))
+	if title != "" {
+		seg.Data["title"] = title
+	}
+	if content != "" {
+		seg.Data["content"] = content
+	}
+	return seg
+}
+
+func musicSeg(musicType, id string) MsgSeg {
+	return newSeg(MusicMsgSeg, SegParam("type", musicType), SegParam("id", id))
+}
+
+func customMusicSeg(url, audio, title, content, image string) MsgSeg {
+	seg := newSeg(MusicMsgSeg, SegParam("type", MusicCustom), SegParam("url", url), SegParam("audio", audio), SegParam("title", title))
+	if content != "" {
+		seg.Data["content"] = content
+	}
+	if image != "" {
+		seg.Data["image"] = image
+	}
+	return seg
+}
+
+func replySeg(msgID int) MsgSeg {
+	return newSeg(ReplyMsgSeg, SegParam("id", strconv.Itoa(msgID)))
+}
+
+func customReplySeg(text string, qq, time int64, seq int) MsgSeg {
+	return newSeg(ReplyMsgSeg,
+		SegParam("text", text),
+		SegParam("qq", strconv.FormatInt(qq, 10)),
+		SegParam("time", strconv.FormatInt(time, 10)),
+		SegParam("seq", strconv.Itoa(seq)))
+}
+
+func forwardSeg(id string) MsgSeg {
+	return newSeg(ForwardMsgSeg, SegParam("id", id))
+}
+
+func nodeSeg(msgID int) MsgSeg {
+	return newSeg(NodeMsgSeg, SegParam("id", strconv.Itoa(msgID)))
+}
+
+func customNodeSeg(name string, uin int64, content string) MsgSeg {
+	return newSeg(NodeMsgSeg, SegParam("name", name), SegParam("uin", strconv.FormatInt(uin, 10)), SegParam("content", content))
+}
+
+func dataSeg(segType, data string, opts ...SegOption) MsgSeg {
+	return newSeg(segType, append([]SegOption{SegParam("data", data)}, opts...)...)
+}
+
+func ttsSeg(text string) MsgSeg {
+	return newSeg(TTSMsgSeg, SegParam("text", text))
+}
+
+func cardImageSeg(file string, opts ...SegOption) MsgSeg {
+	return newSeg(CardImageMsgSeg, append([]SegOption{SegParam("file", file)}, opts...)...)
+}
+
+func giftSeg(qq int64, giftID int) MsgSeg {
+	return newSeg(GiftMsgSeg, SegParam("qq", strconv.FormatInt(qq, 10)), SegParam("id", strconv.Itoa(giftID)))
+}
+
+// FileSegInfo is the data of an image, record or video segment received.
+type FileSegInfo struct {
+	File string
+	URL  string
+	// image: flash, show or empty
+	Type string
+}
+
+// GetSegs returns all of the segments in the message with the type.
+func (e *Event) GetSegs(segType string) []MsgSeg {
+	return e.Message.Segs(segType)
+}
+
+// GetPlainText returns the text segments of the message joined.
+func (e *Event) GetPlainText() string {
+	return e.Message.PlainText()
+}
+
+func (e *Event) GetImages() []FileSegInfo {
+	return e.Message.Images()
+}
+
+func (e *Event) GetRecords() []FileSegInfo {
+	return e.Message.Records()
+}
+
+func (e *Event) GetVideos() []FileSegInfo {
+	return e.Message.Videos()
+}
+
+// GetAts returns the QQ numbers at in the message, AtAll for at all.
+func (e *Event) GetAts() []int64 {
+	return e.Message.Ats()
+}
+
+func (e *Event) GetFaces() []int {
+	return e.Message.Faces()
+}
+
+// GetReplyID returns the id of the message replied to.
+func (e *Event) GetReplyID() (int, bool) {
+	return e.Message.ReplyTo()
+}
@@ -0,0 +1,273 @@
+package luxtbot
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Message is a message made of segments. It is marshaled to the array form,
+// and could be unmarshaled from both the array and the string form.
+type Message []MsgSeg
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]MsgSeg(m))
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*m = ParseMsgSegs(text)
+		return nil
+	}
+	var segs []MsgSeg
+	if err := json.Unmarshal(data, &segs); err != nil {
+		return err
+	}
+	*m = segs
+	return nil
+}
+
+// UnmarshalJSON decodes a segment leniently, the values of the data which
+// are not strings, such as numbers, are kept in their JSON form, and a
+// message in the array form, such as the content of a node, is kept in
+// the string form.
+func (seg *MsgSeg) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type string                     `json:"type"`
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	seg.Type, seg.Data = raw.Type, nil
+	if raw.Data == nil {
+		return nil
+	}
+	seg.Data = make(map[string]string, len(raw.Data))
+	for k, v := range raw.Data {
+		seg.Data[k] = segDataValue(v)
+	}
+	return nil
+}
+
+func segDataValue(v json.RawMessage) string {
+	var str string
+	if json.Unmarshal(v, &str) == nil {
+		return str
+	}
+	text := strings.TrimSpace(string(v))
+	if text == "null" {
+		return ""
+	}
+	var msg Message
+	if strings.HasPrefix(text, "[") && json.Unmarshal(v, &msg) == nil && isSegs(msg) {
+		return msg.String()
+	}
+	return text
+}
+
+// isSegs reports whether every segment has a type,
+// an array of other objects is not a message.
+func isSegs(msg Message) bool {
+	for _, seg := range msg {
+		if seg.Type == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// GetMsg makes Message a MsgBuilder.
+func (m Message) GetMsg() (interface{}, error) {
+	if len(m) == 0 {
+		return nil, ErrEmptyMessage
+	}
+	return m, nil
+}
+
+// String returns the string form with CQ codes.
+func (m Message) String() string {
+	return EncodeMsgSegs(m)
+}
+
+// Segs returns all of the segments with the type.
+func (m Message) Segs(segType string) []MsgSeg {
+	var segs []MsgSeg
+	for _, seg := range m {
+		if seg.Type == segType {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
+
+// PlainText returns the text segments joined.
+func (m Message) PlainText() string {
+	var sb strings.Builder
+	for _, seg := range m.Segs(TextMsgSeg) {
+		sb.WriteString(seg.Data["text"])
+	}
+	return sb.String()
+}
+
+func (m Message) fileSegs(segType string) []FileSegInfo {
+	var infos []FileSegInfo
+	for _, seg := range m.Segs(segType) {
+		infos = append(infos, FileSegInfo{
+			File: seg.Data["file"],
+			URL:  seg.Data["url"],
+			Type: seg.Data["type"],
+		})
+	}
+	return infos
+}
+
+func (m Message) Images() []FileSegInfo {
+	return m.fileSegs(ImageMsgSeg)
+}
+
+func (m Message) Records() []FileSegInfo {
+	return m.fileSegs(RecordMsgSeg)
+}
+
+func (m Message) Videos() []FileSegInfo {
+	return m.fileSegs(VideoMsgSeg)
+}
+
+// Ats returns the QQ numbers at in the message, AtAll for at all.
+func (m Message) Ats() []int64 {
+	var qqs []int64
+	for _, seg := range m.Segs(AtMsgSeg) {
+		if seg.Data["qq"] == "all" {
+			qqs = append(qqs, AtAll)
+			continue
+		}
+		qq, err := strconv.ParseInt(seg.Data["qq"], 10, 64)
+		if err == nil {
+			qqs = append(qqs, qq)
+		}
+	}
+	return qqs
+}
+
+// HasAt reports whether the user, usually a bot, is at in the message.
+func (m Message) HasAt(uid int64) bool {
+	for _, qq := range m.Ats() {
+		if qq == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func (m Message) Faces() []int {
+	var ids []int
+	for _, seg := range m.Segs(FaceMsgSeg) {
+		id, err := strconv.Atoi(seg.Data["id"])
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ReplyTo returns the id of the message quoted.
+func (m Message) ReplyTo() (int, bool) {
+	for _, seg := range m.Segs(ReplyMsgSeg) {
+		id, err := strconv.Atoi(seg.Data["id"])
+		if err == nil {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// StripPrefix removes the prefix from the first text segment,
+// the segments before it such as reply and at are kept.
+// It reports false if the first text segment does not start with prefix.
+func (m Message) StripPrefix(prefix string) (Message, bool) {
+	for i, seg := range m {
+		if seg.Type != TextMsgSeg {
+			continue
+		}
+		text := seg.Data["text"]
+		if !strings.HasPrefix(text, prefix) {
+			return m, false
+		}
+		result := make(Message, 0, len(m))
+		result = append(result, m[:i]...)
+		if rest := text[len(prefix):]; rest != "" {
+			result = append(result, textSeg(rest))
+		}
+		return append(result, m[i+1:]...), true
+	}
+	return m, false
+}
+
+// Split splits the message by sep in the text segments,
+// other segments stay in the part where they are. Empty parts are dropped.
+func (m Message) Split(sep string) []Message {
+	var (
+		parts []Message
+		cur   Message
+	)
+	for _, seg := range m {
+		if seg.Type != TextMsgSeg {
+			cur = append(cur, seg)
+			continue
+		}
+		texts := strings.Split(seg.Data["text"], sep)
+		for i, text := range texts {
+			if i > 0 {
+				if len(cur) != 0 {
+					parts = append(parts, cur)
+				}
+				cur = nil
+			}
+			if text != "" {
+				cur = append(cur, textSeg(text))
+			}
+		}
+	}
+	if len(cur) != 0 {
+		parts = append(parts, cur)
+	}
+	return parts
+}
+
+// Concat returns a new message with the segments of m and msgs.
+func (m Message) Concat(msgs ...Message) Message {
+	size := len(m)
+	for _, msg := range msgs {
+		size += len(msg)
+	}
+	result := make(Message, 0, size)
+	result = append(result, m...)
+	for _, msg := range msgs {
+		result = append(result, msg...)
+	}
+	return result
+}
+
+// Equal reports whether two messages have the same segments,
+// a nil Data is equal to an empty one.
+func (m Message) Equal(other Message) bool {
+	if len(m) != len(other) {
+		return false
+	}
+	for i := range m {
+		if m[i].Type != other[i].Type || len(m[i].Data) != len(other[i].Data) {
+			return false
+		}
+		for k, v := range m[i].Data {
+			if ov, ok := other[i].Data[k]; !ok || ov != v {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,390 @@
+package luxtbot
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Permission nodes are dotted names: plugin.<plugin id>.<unit name> for
+// the units of plugins, and admin.<plugin id>.<unit name> for the units of
+// admin plugins. A pattern grants the node itself and all of the nodes
+// under it, and a trailing * matches anything, like plugin.* or *.
+// A pattern starting with - denies, and denying wins over granting across
+// all of the roles and grants of a user, except for the super admins, who
+// are never denied, so a deny on everyone never locks them out.
+//
+// The permissions of a user are the union of the roles they have and
+// the grants to them, to their group and to them in the group.
+// Roles are the builtin ones below, or custom ones defined in the roles
+// section of the config, which could also override the builtin ones.
+const (
+	PermRoleSuperuser  = "superuser"
+	PermRoleBotAdmin   = "bot-admin"
+	PermRoleGroupOwner = "group-owner"
+	PermRoleGroupAdmin = "group-admin"
+	PermRoleEveryone   = "everyone"
+
+	permDataName = "perm"
+)
+
+var defaultRoles = map[string][]string{
+	PermRoleSuperuser:  {"*"},
+	PermRoleBotAdmin:   {"plugin.*", "admin.*"},
+	PermRoleGroupOwner: {"plugin.*"},
+	PermRoleGroupAdmin: {"plugin.*"},
+	PermRoleEveryone:   {"plugin.*"},
+}
+
+// permGrants are the grants made by chat commands or GrantPerm,
+// every grant is a role name or a permission pattern.
+type permGrants struct {
+	Users  map[int64][]string `json:"users"`
+	Groups map[int64][]string `json:"groups"`
+	// <group id>:<user id>
+	Members map[string][]string `json:"members"`
+}
+
+var (
+	grants    permGrants
+	grantLock sync.RWMutex
+)
+
+// PermTarget is who a permission is granted to. A zero GroupID means
+// the user everywhere, and a zero UserID means everyone in the group.
+type PermTarget struct {
+	UserID  int64
+	GroupID int64
+}
+
+func (t PermTarget) grantsOf(g *permGrants) []string {
+	switch {
+	case t.UserID != 0 && t.GroupID != 0:
+		return g.Members[memberKey(t.GroupID, t.UserID)]
+	case t.GroupID != 0:
+		return g.Groups[t.GroupID]
+	}
+	return g.Users[t.UserID]
+}
+
+func (t PermTarget) setGrants(g *permGrants, list []string) {
+	switch {
+	case t.UserID != 0 && t.GroupID != 0:
+		if g.Members == nil {
+			g.Members = make(map[string][]string)
+		}
+		setOrDelete(g.Members, memberKey(t.GroupID, t.UserID), list)
+	case t.GroupID != 0:
+		if g.Groups == nil {
+			g.Groups = make(map[int64][]string)
+		}
+		setOrDeleteID(g.Groups, t.GroupID, list)
+	default:
+		if g.Users == nil {
+			g.Users = make(map[int64][]string)
+		}
+		setOrDeleteID(g.Users, t.UserID, list)
+	}
+}
+
+func setOrDelete(m map[string][]string, key string, list []string) {
+	if len(list) == 0 {
+		delete(m, key)
+	} else {
+		m[key] = list
+	}
+}
+
+func setOrDeleteID(m map[int64][]string, id int64, list []string) {
+	if len(list) == 0 {
+		delete(m, id)
+	} else {
+		m[id] = list
+	}
+}
+
+// clone copies the maps, the lists are never modified in place.
+func (g *permGrants) clone() permGrants {
+	c := permGrants{
+		Users:   make(map[int64][]string, len(g.Users)),
+		Groups:  make(map[int64][]string, len(g.Groups)),
+		Members: make(map[string][]string, len(g.Members)),
+	}
+	for k, v := range g.Users {
+		c.Users[k] = v
+	}
+	for k, v := range g.Groups {
+		c.Groups[k] = v
+	}
+	for k, v := range g.Members {
+		c.Members[k] = v
+	}
+	return c
+}
+
+func memberKey(groupID, userID int64) string {
+	return strconv.FormatInt(groupID, 10) + ":" + strconv.FormatInt(userID, 10)
+}
+
+// loadPerms loads the grants from the storage.
+func loadPerms() error {
+	var g permGrants
+	if err := LoadData(permDataName, &g); err != nil {
+		return err
+	}
+	grantLock.Lock()
+	grants = g
+	grantLock.Unlock()
+	return nil
+}
+
+// GrantPerm grants a role or a permission pattern to the target and saves it,
+// the grant takes effect only if it is saved.
+func GrantPerm(target PermTarget, perm string) error {
+	if target.UserID == 0 && target.GroupID == 0 || perm == "" {
+		return errors.New(T("perm.bad-grant"))
+	}
+	grantLock.Lock()
+	defer grantLock.Unlock()
+	list := target.grantsOf(&grants)
+	for _, p := range list {
+		if p == perm {
+			return nil
+		}
+	}
+	next := grants.clone()
+	target.setGrants(&next, append(list[:len(list):len(list)], perm))
+	return saveGrants(next)
+}
+
+// RevokePerm revokes a role or a permission pattern granted to the target and saves it,
+// the grant is kept if it could not be saved.
+func RevokePerm(target PermTarget, perm string) error {
+	grantLock.Lock()
+	defer grantLock.Unlock()
+	list := target.grantsOf(&grants)
+	for i, p := range list {
+		if p == perm {
+			next := grants.clone()
+			target.setGrants(&next, append(list[:i:i], list[i+1:]...))
+			return saveGrants(next)
+		}
+	}
+	return errors.New(T("perm.not-granted", perm))
+}
+
+// saveGrants saves the grants and swaps them in, grantLock must be held.
+func saveGrants(next permGrants) error {
+	if err := SaveData(permDataName, next); err != nil {
+		return err
+	}
+	grants = next
+	return nil
+}
+
+// GetGrants returns the roles and permission patterns granted to the target.
+func GetGrants(target PermTarget) []string {
+	grantLock.RLock()
+	defer grantLock.RUnlock()
+	return append([]string(nil), target.grantsOf(&grants)...)
+}
+
+// RolesOf returns the builtin roles the sender of the event has.
+func RolesOf(e *Event, bInfo BotInfo) []string {
+	roles := []string{PermRoleEveryone}
+	if containsID(CurConf().SAdmins, e.UserID) {
+		roles = append(roles, PermRoleSuperuser)
+	}
+	if containsID(bInfo.Admins, e.UserID) {
+		roles = append(roles, PermRoleBotAdmin)
+	}
+	if e.GroupID != 0 {
+		switch e.Sender.Role {
+		case RoleOwner:
+			roles = append(roles, PermRoleGroupOwner)
+		case RoleAdmin:
+			roles = append(roles, PermRoleGroupAdmin)
+		}
+	}
+	return roles
+}
+
+// rolePerms returns the patterns of the role, false if it is not a role.
+func rolePerms(role string) ([]string, bool) {
+	if perms, ok := CurConf().Roles[role]; ok {
+		return perms, true
+	}
+	perms, ok := defaultRoles[role]
+	return perms, ok
+}
+
+// permsOf collects the patterns of the sender of the event.
+func permsOf(e *Event, bInfo BotInfo) []string {
+	entries := RolesOf(e, bInfo)
+	grantLock.RLock()
+	entries = append(entries, grants.Users[e.UserID]...)
+	if e.GroupID != 0 {
+		entries = append(entries, grants.Groups[e.GroupID]...)
+		entries = append(entries, grants.Members[memberKey(e.GroupID, e.UserID)]...)
+	}
+	grantLock.RUnlock()
+	var perms []string
+	for _, entry := range entries {
+		if rp, ok := rolePerms(entry); ok {
+			perms = append(perms, rp...)
+		} else {
+			perms = append(perms, entry)
+		}
+	}
+	return perms
+}
+
+// matchPerm reports whether the pattern covers the node.
+func matchPerm(pattern, node string) bool {
+	switch {
+	case pattern == "*" || pattern == node:
+		return true
+	case strings.HasSuffix(pattern, ".*"):
+		return strings.HasPrefix(node, pattern[:len(pattern)-1])
+	}
+	return strings.HasPrefix(node, pattern+".")
+}
+
+// HasPerm reports whether the sender of the event has the permission node,
+// the denying patterns are ignored for the super admins.
+func HasPerm(e *Event, bInfo BotInfo, node string) bool {
+	allowed := false
+	sAdmin := containsID(CurConf().SAdmins, e.UserID)
+	for _, p := range permsOf(e, bInfo) {
+		if strings.HasPrefix(p, "-") {
+			if !sAdmin && matchPerm(p[1:], node) {
+				return false
+			}
+		} else if !allowed && matchPerm(p, node) {
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// PermNode returns the permission node of a unit of the plugin,
+// the node of the plugin itself if unit is empty.
+func PermNode(plg *Plugin, unit string) string {
+	node := "plugin."
+	if plg.IsAdminPlugin {
+		node = "admin."
+	}
+	node += strconv.Itoa(plg.ID)
+	if unit != "" {
+		node += "." + unit
+	}
+	return node
+}
+
+// HasPermOf makes a Judge checking the permission node.
+func HasPermOf(node string) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		return HasPerm(e, bInfo, node)
+	}
+}
+
+// checkPerm checks the permission of the sender of message events only,
+// the user of a notice or request event is not the one asking for the unit.
+func checkPerm(plg *Plugin, unit string, e *Event, bInfo BotInfo) bool {
+	if e.PostType != MessageEvent {
+		return true
+	}
+	return HasPerm(e, bInfo, PermNode(plg, unit))
+}
+
+func InitDefaultPermManager(plgId int) {
+	plg := NewPlugin(plgId).SetName("Luxtbot权限管理").setI18n("perm.name", "perm.info").SetAdminPlugin()
+	rule := NewRule().AddMustRules(IsSAdmin)
+	addGrantUnits(plg, rule)
+	addPermsUnit(plg, rule)
+}
+
+// grant|revoke user <qq> <perm>
+// grant|revoke group <group id> <perm>
+// grant|revoke member <group id> <qq> <perm>
+func addGrantUnits(plg *Plugin, rule *Rule) {
+	ops := []struct {
+		cmd     string
+		aliases []string
+		do      func(target PermTarget, perm string) error
+		done    string
+	}{
+		{"grant", []string{"授权"}, GrantPerm, "perm.granted"},
+		{"revoke", []string{"撤销授权"}, RevokePerm, "perm.revoked"},
+	}
+	for _, op := range ops {
+		op := op
+		plg.AddCommandUnit().SetCommand(op.cmd).AddAliases(op.aliases...).SetRule(rule).SetProcessor(func(e *Event, params []string, bInfo BotInfo) {
+			msg := MakeArrayMsg(1)
+			target, rest, err := parsePermTarget(params)
+			if err == nil && len(rest) != 1 {
+				err = errors.New(T("perm.usage", op.cmd))
+			}
+			if err == nil {
+				err = op.do(target, rest[0])
+			}
+			if err != nil {
+				msg.AddText(err.Error())
+			} else {
+				msg.AddText(T(op.done, rest[0]))
+			}
+			sendMsg(msg, e, bInfo)
+		}).AddToCmdChain()
+	}
+}
+
+// perms user <qq> | perms group <group id> | perms member <group id> <qq>
+func addPermsUnit(plg *Plugin, rule *Rule) {
+	plg.AddCommandUnit().SetCommand("perms").AddAliases("权限").SetRule(rule).SetProcessor(func(e *Event, params []string, bInfo BotInfo) {
+		msg := MakeArrayMsg(1)
+		target, _, err := parsePermTarget(params)
+		if err != nil {
+			msg.AddText(err.Error())
+		} else {
+			perms := GetGrants(target)
+			sort.Strings(perms)
+			msg.AddText(T("perm.list", strings.Join(perms, ", ")))
+		}
+		sendMsg(msg, e, bInfo)
+	}).AddToCmdChain()
+}
+
+func parsePermTarget(params []string) (PermTarget, []string, error) {
+	var target PermTarget
+	if len(params) < 2 {
+		return target, nil, errors.New(T("perm.usage", "perms"))
+	}
+	ids := []int64{}
+	n := 1
+	if params[0] == "member" {
+		n = 2
+	}
+	if len(params) < 1+n {
+		return target, nil, errors.New(T("perm.usage", "perms"))
+	}
+	for _, s := range params[1 : 1+n] {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return target, nil, errors.New(T("perm.bad-id", s))
+		}
+		ids = append(ids, id)
+	}
+	switch params[0] {
+	case "user":
+		target.UserID = ids[0]
+	case "group":
+		target.GroupID = ids[0]
+	case "member":
+		target.GroupID, target.UserID = ids[0], ids[1]
+	default:
+		return target, nil, errors.New(T("perm.usage", "perms"))
+	}
+	return target, params[1+n:], nil
+}
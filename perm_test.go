@@ -0,0 +1,151 @@
+package luxtbot
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchPerm(t *testing.T) {
+	tests := []struct {
+		pattern, node string
+		want          bool
+	}{
+		{"*", "plugin.1.a", true},
+		{"plugin.1.a", "plugin.1.a", true},
+		{"plugin.1", "plugin.1.a", true},
+		{"plugin.1", "plugin.10.a", false},
+		{"plugin.*", "plugin.1.a", true},
+		{"plugin.*", "admin.1.a", false},
+		{"plugin.1.*", "plugin.1", false},
+		{"admin", "admin.1", true},
+		{"plugin.1.a", "plugin.1", false},
+	}
+	for _, tt := range tests {
+		if got := matchPerm(tt.pattern, tt.node); got != tt.want {
+			t.Errorf("matchPerm(%q, %q) = %v, want %v", tt.pattern, tt.node, got, tt.want)
+		}
+	}
+}
+
+// useGrants starts the test with the grants and restores them after.
+func useGrants(t *testing.T, g permGrants) {
+	grantLock.Lock()
+	old := grants
+	grants = g
+	grantLock.Unlock()
+	t.Cleanup(func() {
+		grantLock.Lock()
+		grants = old
+		grantLock.Unlock()
+	})
+}
+
+func TestHasPerm(t *testing.T) {
+	conf := *CurConf()
+	conf.SAdmins = []int64{1}
+	conf.Roles = map[string][]string{
+		PermRoleEveryone: {"plugin.*", "-plugin.3", "-admin.*"},
+		"operator":       {"admin.5"},
+	}
+	useConf(t, conf)
+	useGrants(t, permGrants{
+		Users:   map[int64][]string{4: {"operator"}, 5: {"plugin.3"}},
+		Groups:  map[int64][]string{100: {"-plugin.2"}},
+		Members: map[string][]string{memberKey(100, 6): {"admin.5.x"}},
+	})
+	bInfo := BotInfo{BotID: 10, Admins: []int64{2}}
+	tests := []struct {
+		name    string
+		userID  int64
+		groupID int64
+		node    string
+		want    bool
+	}{
+		{"everyone", 3, 0, "plugin.1.a", true},
+		{"denied on everyone", 3, 0, "plugin.3.a", false},
+		{"granted but denied", 5, 0, "plugin.3.a", false},
+		{"denied in the group", 3, 100, "plugin.2.a", false},
+		{"not denied elsewhere", 3, 200, "plugin.2.a", true},
+		{"admin denied on everyone", 2, 0, "admin.5.a", false},
+		{"custom role denied", 4, 0, "admin.5.a", false},
+		{"member grant denied", 6, 100, "admin.5.x", false},
+		// a deny on everyone never locks out the super admins
+		{"sadmin admin", 1, 0, "admin.5.a", true},
+		{"sadmin plugin", 1, 0, "plugin.3.a", true},
+		{"sadmin in the group", 1, 100, "plugin.2.a", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Event{PostType: MessageEvent, UserID: tt.userID, GroupID: tt.groupID}
+			if got := HasPerm(e, bInfo, tt.node); got != tt.want {
+				t.Errorf("HasPerm(%d, %d, %q) = %v, want %v", tt.userID, tt.groupID, tt.node, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPermMessagesOnly(t *testing.T) {
+	conf := *CurConf()
+	conf.Roles = map[string][]string{PermRoleEveryone: {"-*"}}
+	useConf(t, conf)
+	useGrants(t, permGrants{})
+	plg := &Plugin{ID: 1}
+	if checkPerm(plg, "a", &Event{PostType: MessageEvent, UserID: 3}, BotInfo{}) {
+		t.Error("checkPerm() of a message passed a deny on everyone")
+	}
+	if !checkPerm(plg, "a", &Event{PostType: NoticeEvent, UserID: 3}, BotInfo{}) {
+		t.Error("checkPerm() of a notice checked the user")
+	}
+}
+
+func TestGrantPerm(t *testing.T) {
+	useDataDir(t, t.TempDir())
+	useGrants(t, permGrants{})
+	target := PermTarget{UserID: 3, GroupID: 100}
+	steps := []struct {
+		name string
+		do   func() error
+		want []string
+	}{
+		{"grant", func() error { return GrantPerm(target, "admin.5") }, []string{"admin.5"}},
+		{"grant again", func() error { return GrantPerm(target, "admin.5") }, []string{"admin.5"}},
+		{"grant role", func() error { return GrantPerm(target, "operator") }, []string{"admin.5", "operator"}},
+		{"revoke", func() error { return RevokePerm(target, "admin.5") }, []string{"operator"}},
+	}
+	for _, step := range steps {
+		if err := step.do(); err != nil {
+			t.Fatalf("%v: %v", step.name, err)
+		}
+		if got := GetGrants(target); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%v: GetGrants() = %v, want %v", step.name, got, step.want)
+		}
+	}
+	if err := RevokePerm(target, "admin.5"); err == nil {
+		t.Error("RevokePerm() of a pattern not granted succeeded")
+	}
+	if err := GrantPerm(PermTarget{}, "admin.5"); err == nil {
+		t.Error("GrantPerm() without a target succeeded")
+	}
+}
+
+// the grants are left as they are if they could not be saved
+func TestGrantPermSaveFail(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	if err := ioutil.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	useDataDir(t, dir)
+	target := PermTarget{UserID: 3}
+	useGrants(t, permGrants{Users: map[int64][]string{3: {"admin.5"}}})
+	if err := GrantPerm(target, "admin.6"); err == nil {
+		t.Error("GrantPerm() succeeded without saving")
+	}
+	if err := RevokePerm(target, "admin.5"); err == nil {
+		t.Error("RevokePerm() succeeded without saving")
+	}
+	if got, want := GetGrants(target), []string{"admin.5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetGrants() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+package luxtbot
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ConfValidator could be implemented by a plugin config section,
+// Validate is called every time after the section is decoded.
+type ConfValidator interface {
+	Validate() error
+}
+
+type confSection struct {
+	ptr      interface{}
+	typ      reflect.Type
+	defaults []byte
+	current  interface{}
+	onChange []func(old, new interface{})
+}
+
+var (
+	confSections     = make(map[string]*confSection)
+	confSectionsLock sync.RWMutex
+)
+
+// RegisterConfSection declares a typed config section plugins.<name>.
+// ptr must be a pointer to a struct holding the default values,
+// it is filled with the section of the config file when luxtbot is initialized.
+// The reloads never modify it while the handlers may be reading it, use
+// GetConfSection or OnConfSectionChange for the reloaded values.
+// The section is decoded by its yaml tags whatever the format of the config file is.
+func RegisterConfSection(name string, ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New(T("err.plg-conf-ptr", name))
+	}
+	defaults, err := yaml.Marshal(ptr)
+	if err != nil {
+		return errors.New(T("err.plg-conf-def", name, err))
+	}
+	sec := &confSection{
+		ptr:      ptr,
+		typ:      v.Elem().Type(),
+		defaults: defaults,
+		current:  ptr,
+	}
+	// registered after Init, decode it right now
+	if Conf.sections != nil {
+		val, err := sec.decode(CurConf().Plugins[name])
+		if err != nil {
+			return fmt.Errorf("plugins.%v: %v", name, err)
+		}
+		sec.fill(val)
+	}
+	confSectionsLock.Lock()
+	confSections[name] = sec
+	confSectionsLock.Unlock()
+	return nil
+}
+
+// decode makes a new value filled with the defaults and the raw section.
+func (sec *confSection) decode(raw interface{}) (interface{}, error) {
+	val := reflect.New(sec.typ).Interface()
+	err := yaml.Unmarshal(sec.defaults, val)
+	if err != nil {
+		return nil, err
+	}
+	if raw != nil {
+		data, err := yaml.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		err = yaml.UnmarshalStrict(data, val)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := val.(ConfValidator); ok {
+		err = v.Validate()
+	}
+	return val, err
+}
+
+// fill stores the value and also copies it into the registered pointer,
+// only before the section is used.
+func (sec *confSection) fill(val interface{}) {
+	sec.current = val
+	reflect.ValueOf(sec.ptr).Elem().Set(reflect.ValueOf(val).Elem())
+}
+
+// GetConfSection returns the latest value of a registered section,
+// every reload makes a new value, so never modify it.
+func GetConfSection(name string) interface{} {
+	confSectionsLock.RLock()
+	defer confSectionsLock.RUnlock()
+	if sec, ok := confSections[name]; ok {
+		return sec.current
+	}
+	return nil
+}
+
+// OnConfSectionChange adds a callback which is called when the section
+// is changed by reloading the config file.
+func OnConfSectionChange(name string, f func(old, new interface{})) {
+	confSectionsLock.Lock()
+	defer confSectionsLock.Unlock()
+	if sec, ok := confSections[name]; ok {
+		sec.onChange = append(sec.onChange, f)
+	}
+}
+
+// decodeConfSections decodes all of the registered sections,
+// the results are applied only when the whole config is valid.
+func decodeConfSections(raw map[string]interface{}) (map[string]interface{}, error) {
+	confSectionsLock.RLock()
+	defer confSectionsLock.RUnlock()
+	sections := make(map[string]interface{}, len(confSections))
+	for name, sec := range confSections {
+		val, err := sec.decode(raw[name])
+		if err != nil {
+			return nil, fmt.Errorf("plugins.%v: %v", name, err)
+		}
+		sections[name] = val
+	}
+	return sections, nil
+}
+
+func applyConfSections(sections map[string]interface{}) {
+	confSectionsLock.Lock()
+	defer confSectionsLock.Unlock()
+	for name, val := range sections {
+		sec := confSections[name]
+		old := sec.current
+		if !isStarted {
+			sec.fill(val)
+			continue
+		}
+		sec.current = val
+		if !reflect.DeepEqual(old, val) {
+			LBLogger.Infoln(T("conf.changed", "plugins."+name, fmt.Sprintf("%+v", reflect.ValueOf(old).Elem()), fmt.Sprintf("%+v", reflect.ValueOf(val).Elem())))
+			for _, f := range sec.onChange {
+				go f(old, val)
+			}
+		}
+	}
+	for name := range CurConf().Plugins {
+		if _, ok := confSections[name]; !ok {
+			LBLogger.WithField("Section", "plugins."+name).Warnln(T("conf.section-unused"))
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ABiao0306/luxtbot/util"
 )
@@ -19,23 +20,32 @@ var (
 	PluginList []*Plugin
 )
 
-const (
-	defaultPluginInfo = "写该插件的人很懒，没有留下任何信息！"
-)
+// catalog key of the help info of a plugin without one
+const defaultPluginInfo = "plugin.no-info"
 
 type Plugin struct {
-	ID            int
+	ID int
+	// the name identifies the plugin in the config, see DisplayName for the one shown
 	Name          string
 	Enable        bool
 	HelpInfo      string
 	IsAdminPlugin bool
+
+	// catalog keys of the name and the help info of the builtin plugins
+	nameKey string
+	infoKey string
+	hasConf bool
+	// locale -> key -> template
+	templates map[string]map[string]string
+	workers   chan struct{}
+	stats     *pluginStats
 }
 
 func NewPlugin(id int) *Plugin {
 	var p Plugin
 	p.ID = id
 	p.Enable = true
-	p.HelpInfo = defaultPluginInfo
+	p.stats = new(pluginStats)
 	PluginList = append(PluginList, &p)
 	return &p
 }
@@ -50,11 +60,67 @@ func (p *Plugin) SetHelpInfo(helpInfo string) *Plugin {
 	return p
 }
 
+// setI18n makes the name and the help info shown in the locale of the config.
+func (p *Plugin) setI18n(nameKey, infoKey string) *Plugin {
+	p.nameKey, p.infoKey = nameKey, infoKey
+	return p
+}
+
+// DisplayName returns the name shown to the users.
+func (p *Plugin) DisplayName() string {
+	if p.nameKey != "" {
+		return T(p.nameKey)
+	}
+	return p.Name
+}
+
+// Info returns the help info shown to the users.
+func (p *Plugin) Info() string {
+	if p.infoKey != "" {
+		return T(p.infoKey)
+	}
+	if p.HelpInfo == "" {
+		return T(defaultPluginInfo)
+	}
+	return p.HelpInfo
+}
+
 func (p *Plugin) SetAdminPlugin() *Plugin {
 	p.IsAdminPlugin = true
 	return p
 }
 
+// SetConfig declares the config of the plugin, conf must be a pointer to
+// a struct holding the default values. It is populated from the section
+// plugins.<Name> of the config file at Init, so call it after SetName.
+// Reloading never modifies conf, the reloaded values are got by Config.
+// If conf implements ConfValidator, it is validated on every load.
+func (p *Plugin) SetConfig(conf interface{}) *Plugin {
+	err := RegisterConfSection(p.Name, conf)
+	if err != nil {
+		panic(T("err.plg-conf-reg", p.Name, err))
+	}
+	p.hasConf = true
+	return p
+}
+
+// Config returns the latest config of the plugin, the same type as the
+// pointer passed to SetConfig. Reloading the config makes a new value,
+// so always get it by Config() rather than keep it.
+func (p *Plugin) Config() interface{} {
+	if !p.hasConf {
+		return nil
+	}
+	return GetConfSection(p.Name)
+}
+
+// OnConfigChange adds a callback which is called with the old and new
+// config when the config of the plugin is changed by reloading.
+func (p *Plugin) OnConfigChange(f func(old, new interface{})) *Plugin {
+	OnConfSectionChange(p.Name, f)
+	return p
+}
+
 func (p *Plugin) AddBackenUnit() *BackenUnit {
 	var bp BackenUnit
 	bp.Plg = p
@@ -109,7 +175,39 @@ func (bp *BackenUnit) AddToBackenChain() {
 type MessageUnit struct {
 	Rule    *Rule
 	Plg     *Plugin
+	Name    string
+	Timeout time.Duration
 	Process func(e *Event, bInfo BotInfo)
+	// used instead of Process if set
+	Handler func(c *Ctx)
+}
+
+// SetHandler sets the handler taking a *Ctx, which is used instead of the processor.
+func (mp *MessageUnit) SetHandler(f func(c *Ctx)) *MessageUnit {
+	mp.Handler = f
+	return mp
+}
+
+func (mp *MessageUnit) handler() func(c *Ctx) {
+	if mp.Handler != nil {
+		return mp.Handler
+	}
+	return func(c *Ctx) {
+		mp.Process(c.Event, c.BotInfo())
+	}
+}
+
+// SetTimeout sets the deadline of processing an event, unit-timeout of the config is used if 0.
+func (mp *MessageUnit) SetTimeout(timeout time.Duration) *MessageUnit {
+	mp.Timeout = timeout
+	return mp
+}
+
+// SetName names the unit, so that the rule of the key "<plugin name>.<unit name>"
+// in rules of the config applies to it.
+func (mp *MessageUnit) SetName(name string) *MessageUnit {
+	mp.Name = name
+	return mp
 }
 
 func (mp *MessageUnit) SetProcessor(f func(e *Event, bInfo BotInfo)) *MessageUnit {
@@ -130,11 +228,31 @@ func (mp *MessageUnit) AddToMsgChain() {
 const ConmandPrefix = "~$#"
 
 type CommandUnit struct {
-	Plg     *Plugin
-	Rule    *Rule
+	Plg  *Plugin
+	Rule *Rule
+	// the command is used if empty
+	Name    string
 	Cmd     string
+	Timeout time.Duration
 	Aliases []string
 	Process func(e *Event, params []string, bInfo BotInfo)
+	// used instead of Process if set, the params are in Ctx.Args
+	Handler func(c *Ctx)
+}
+
+// SetHandler sets the handler taking a *Ctx, which is used instead of the processor.
+func (cp *CommandUnit) SetHandler(f func(c *Ctx)) *CommandUnit {
+	cp.Handler = f
+	return cp
+}
+
+func (cp *CommandUnit) handler() func(c *Ctx) {
+	if cp.Handler != nil {
+		return cp.Handler
+	}
+	return func(c *Ctx) {
+		cp.Process(c.Event, c.Args, c.BotInfo())
+	}
 }
 
 func (cp *CommandUnit) SetCommand(cmd string) *CommandUnit {
@@ -142,6 +260,26 @@ func (cp *CommandUnit) SetCommand(cmd string) *CommandUnit {
 	return cp
 }
 
+// SetName names the unit, so that the rule of the key "<plugin name>.<unit name>"
+// in rules of the config applies to it.
+func (cp *CommandUnit) SetName(name string) *CommandUnit {
+	cp.Name = name
+	return cp
+}
+
+// SetTimeout sets the deadline of processing an event, unit-timeout of the config is used if 0.
+func (cp *CommandUnit) SetTimeout(timeout time.Duration) *CommandUnit {
+	cp.Timeout = timeout
+	return cp
+}
+
+func (cp *CommandUnit) unitName() string {
+	if cp.Name == "" {
+		return cp.Cmd
+	}
+	return cp.Name
+}
+
 func (cp *CommandUnit) AddAliases(aliases ...string) *CommandUnit {
 	if len(cp.Aliases) == 0 {
 		cp.Aliases = aliases
@@ -186,6 +324,9 @@ func (cp *CommandUnit) matchCmd(cmd, qq string, botID int64) bool {
 func parseCmd(e *Event) (string, string) {
 	msg := e.GetArrayMsg()
 	cmd, text, qq := "", "", ""
+	if len(msg) == 0 {
+		return cmd, qq
+	}
 	if msg[0].Type == AtMsgSeg {
 		qq = msg[0].Data["qq"]
 		if len(msg) < 2 {
@@ -197,7 +338,7 @@ func parseCmd(e *Event) (string, string) {
 		cmd = args[0]
 	} else if msg[0].Type == TextMsgSeg {
 		text = msg[0].Data["text"]
-		if text[0] == '~' || text[0] == '$' || text[0] == '#' {
+		if text != "" && strings.ContainsRune(ConmandPrefix, rune(text[0])) {
 			args := strings.SplitN(text, " ", 2)
 			cmd = args[0][1:]
 		}
@@ -209,14 +350,17 @@ func parseParams(e *Event) []string {
 	msg := e.GetArrayMsg()
 	var text string
 	var args []string
-	if msg[0].Type == AtMsgSeg {
+	if len(msg) == 0 {
+		return []string{}
+	}
+	if msg[0].Type == AtMsgSeg && len(msg) > 1 {
 		text = util.Trim(msg[1].Data["text"])
 		// LBLogger.Debugln("text is ", text)
 		args = strings.Split(text, " ")
 	} else if msg[0].Type == TextMsgSeg {
 		text = msg[0].Data["text"]
-		if text[0] == '~' || text[0] == '$' || text[0] == '#' {
-			args = strings.SplitN(text, " ", 2)
+		if text != "" && strings.ContainsRune(ConmandPrefix, rune(text[0])) {
+			args = strings.Split(text, " ")
 		}
 	}
 	if len(args) <= 1 {
@@ -228,7 +372,39 @@ func parseParams(e *Event) []string {
 type NoticeUnit struct {
 	Rule    *Rule
 	Plg     *Plugin
+	Name    string
+	Timeout time.Duration
 	Process func(e *Event, bInfo BotInfo)
+	// used instead of Process if set
+	Handler func(c *Ctx)
+}
+
+// SetHandler sets the handler taking a *Ctx, which is used instead of the processor.
+func (np *NoticeUnit) SetHandler(f func(c *Ctx)) *NoticeUnit {
+	np.Handler = f
+	return np
+}
+
+func (np *NoticeUnit) handler() func(c *Ctx) {
+	if np.Handler != nil {
+		return np.Handler
+	}
+	return func(c *Ctx) {
+		np.Process(c.Event, c.BotInfo())
+	}
+}
+
+// SetTimeout sets the deadline of processing an event, unit-timeout of the config is used if 0.
+func (np *NoticeUnit) SetTimeout(timeout time.Duration) *NoticeUnit {
+	np.Timeout = timeout
+	return np
+}
+
+// SetName names the unit, so that the rule of the key "<plugin name>.<unit name>"
+// in rules of the config applies to it.
+func (np *NoticeUnit) SetName(name string) *NoticeUnit {
+	np.Name = name
+	return np
 }
 
 func (np *NoticeUnit) SetProcessor(f func(e *Event, bInfo BotInfo)) *NoticeUnit {
@@ -248,7 +424,39 @@ func (np *NoticeUnit) AddToNoticeChain() {
 type RequestUnit struct {
 	Rule    *Rule
 	Plg     *Plugin
+	Name    string
+	Timeout time.Duration
 	Process func(e *Event, bInfo BotInfo)
+	// used instead of Process if set
+	Handler func(c *Ctx)
+}
+
+// SetHandler sets the handler taking a *Ctx, which is used instead of the processor.
+func (rp *RequestUnit) SetHandler(f func(c *Ctx)) *RequestUnit {
+	rp.Handler = f
+	return rp
+}
+
+func (rp *RequestUnit) handler() func(c *Ctx) {
+	if rp.Handler != nil {
+		return rp.Handler
+	}
+	return func(c *Ctx) {
+		rp.Process(c.Event, c.BotInfo())
+	}
+}
+
+// SetTimeout sets the deadline of processing an event, unit-timeout of the config is used if 0.
+func (rp *RequestUnit) SetTimeout(timeout time.Duration) *RequestUnit {
+	rp.Timeout = timeout
+	return rp
+}
+
+// SetName names the unit, so that the rule of the key "<plugin name>.<unit name>"
+// in rules of the config applies to it.
+func (rp *RequestUnit) SetName(name string) *RequestUnit {
+	rp.Name = name
+	return rp
 }
 
 func (rp *RequestUnit) SetProcessor(f func(e *Event, bInfo BotInfo)) *RequestUnit {
@@ -266,7 +474,7 @@ func (rp *RequestUnit) AddToRequestChain() {
 }
 
 func InitDefaultPluginManager(plgId int) {
-	plg := NewPlugin(plgId).SetName("Luxtbot插件管理").SetHelpInfo("Luxtbot默认插件管理").SetAdminPlugin()
+	plg := NewPlugin(plgId).SetName("Luxtbot插件管理").setI18n("plugin.mgr-name", "plugin.mgr-info").SetAdminPlugin()
 	rule := NewRule().AddMustRules(IsAdmin)
 	addQueryUnit(plg, rule)
 	addOnOffUnit(plg, plgId, rule)
@@ -281,7 +489,7 @@ func addQueryUnit(plg *Plugin, rule *Rule) {
 			if !plg.Enable {
 				state = "OFF"
 			}
-			msgText := fmt.Sprintf("%d. %v: %v - %v \n", plg.ID, plg.Name, plg.HelpInfo, state)
+			msgText := fmt.Sprintf("%d. %v: %v - %v \n", plg.ID, plg.DisplayName(), plg.Info(), state)
 			msg.AddText(msgText)
 		}
 		sendMsg(msg, e, bInfo)
@@ -296,10 +504,10 @@ func addOnOffUnit(plg *Plugin, selfID int, rule *Rule) {
 		if err != nil {
 			msg.AddText(err.Error())
 		} else if plg.ID == selfID {
-			msg.AddText(fmt.Sprintln("无法禁用或开启插件管理插件！"))
+			msg.AddText(T("plugin.self"))
 		} else {
 			plg.Enable = true
-			msg.AddText(fmt.Sprintln("已开启插件：", plg.ID, plg.Name))
+			msg.AddText(T("plugin.on", plg.ID, plg.DisplayName()))
 		}
 		sendMsg(msg, e, bInfo)
 	}).AddToCmdChain()
@@ -310,10 +518,10 @@ func addOnOffUnit(plg *Plugin, selfID int, rule *Rule) {
 		if err != nil {
 			msg.AddText(err.Error())
 		} else if plg.ID == selfID {
-			msg.AddText(fmt.Sprintln("无法禁用或开启插件管理插件！"))
+			msg.AddText(T("plugin.self"))
 		} else {
 			plg.Enable = false
-			msg.AddText(fmt.Sprintln("已关闭插件：", plg.ID, plg.Name))
+			msg.AddText(T("plugin.off", plg.ID, plg.DisplayName()))
 		}
 		sendMsg(msg, e, bInfo)
 	}).AddToCmdChain()
@@ -326,7 +534,7 @@ func searchPugin(idStr string) (*Plugin, error) {
 	)
 	id, err = strconv.Atoi(idStr)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintln("插件id格式错误：", id))
+		return nil, errors.New(T("plugin.bad-id", idStr))
 	}
 	l, r, m := 0, len(PluginList), 0
 	for l <= r {
@@ -339,17 +547,16 @@ func searchPugin(idStr string) (*Plugin, error) {
 			return PluginList[m], nil
 		}
 	}
-	return nil, errors.New(fmt.Sprintln("未找到插件：", id))
+	return nil, errors.New(T("plugin.not-found", id))
 }
 
+// sendMsg replies without waiting for the response, so that the built-in
+// units never hold a worker.
 func sendMsg(msg MsgBuilder, e *Event, bInfo BotInfo) {
-	var api ApiPost
-	if e.MessageType == MsgTypePrivate {
-		api = MakePrivateMsg(msg, e.UserID)
-	} else if e.MessageType == MsgTypeGroup {
-		api = MakeGroupMsg(msg, e.GroupID)
+	api, err := e.replyApi(msg)
+	if err == nil {
+		_, err = api.Do(bInfo.BotID, false)
 	}
-	_, err := api.Do(bInfo.BotID, false)
 	if err != nil {
 		LBLogger.WithField("BotName", bInfo.Name).Warnln(err)
 	}
@@ -0,0 +1,251 @@
+package luxtbot
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const DefaultReloadInterval = 5
+
+var reloadLock sync.Mutex
+
+// RunConfWatcher reloads the config file when SIGHUP is received,
+// and also when the file is modified if hot-reload is on.
+func RunConfWatcher() {
+	if confPath == "" {
+		return
+	}
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGHUP)
+		for range sigChan {
+			LBLogger.Infoln(T("conf.sighup"))
+			ReloadConf()
+		}
+	}()
+	if !Conf.HotReload {
+		return
+	}
+	interval := Conf.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	go watchConfFile(confPath, time.Second*time.Duration(interval))
+}
+
+func watchConfFile(path string, interval time.Duration) {
+	LBLogger.WithField("Conf", path).Infoln(T("conf.watching", interval))
+	modTime := fileModTime(path)
+	for {
+		time.Sleep(interval)
+		mt := fileModTime(path)
+		if mt.IsZero() || mt.Equal(modTime) {
+			continue
+		}
+		modTime = mt
+		LBLogger.WithField("Conf", path).Infoln(T("conf.modified"))
+		ReloadConf()
+	}
+}
+
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// ReloadConf reads the config file again and applies the changes.
+// If the new config is invalid, the old one will be kept.
+func ReloadConf() error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	newConf, err := loadConf(confPath)
+	if err != nil {
+		LBLogger.WithField("Conf", confPath).Errorln(T("conf.reload-failed", err))
+		return err
+	}
+	applyConf(newConf)
+	return nil
+}
+
+// applyConf swaps the running config for a copy with the changes applied,
+// so that the readers always see a consistent one. What depends on the
+// running config is reset by defer, after the swap.
+func applyConf(newConf Config) {
+	conf := *CurConf()
+	if newConf.LogConf.Level != conf.LogConf.Level {
+		LBLogger.Infoln(T("conf.changed", "log.level", conf.LogConf.Level, newConf.LogConf.Level))
+		conf.LogConf.Level = newConf.LogConf.Level
+		SetLogLevel(newConf.LogConf.Level)
+	}
+	if newConf.LogConf.MaxFiles != conf.LogConf.MaxFiles {
+		LBLogger.Warnln(T("conf.changed-restart", "log.max-files", conf.LogConf.MaxFiles, newConf.LogConf.MaxFiles))
+	}
+	if !reflect.DeepEqual(newConf.SAdmins, conf.SAdmins) {
+		LBLogger.Infoln(T("conf.changed", "s-admin", conf.SAdmins, newConf.SAdmins))
+		conf.SAdmins = newConf.SAdmins
+	}
+	if newConf.CallbackPoolSize != conf.CallbackPoolSize {
+		LBLogger.Warnln(T("conf.changed-restart", "callback-pool-size", conf.CallbackPoolSize, newConf.CallbackPoolSize))
+	}
+	if newConf.HotReload != conf.HotReload || newConf.ReloadInterval != conf.ReloadInterval {
+		LBLogger.Warnln(T("conf.changed-key-rest", "hot-reload"))
+	}
+	if newConf.Render != conf.Render {
+		LBLogger.Infoln(T("conf.changed", "render", fmt.Sprintf("%+v", conf.Render), fmt.Sprintf("%+v", newConf.Render)))
+		conf.Render = newConf.Render
+		defer resetRenderer()
+	}
+	if newConf.Locale != conf.Locale {
+		LBLogger.Infoln(T("conf.changed", "locale", conf.Locale, newConf.Locale))
+		conf.Locale = newConf.Locale
+	}
+	if !reflect.DeepEqual(newConf.Templates, conf.Templates) {
+		LBLogger.Infoln(T("conf.changed-key", "templates"))
+		conf.Templates = newConf.Templates
+	}
+	if !reflect.DeepEqual(newConf.Rules, conf.Rules) || !reflect.DeepEqual(newConf.Lists, conf.Lists) {
+		LBLogger.Infoln(T("conf.changed-key", "rules"))
+		conf.Rules, conf.Lists = newConf.Rules, newConf.Lists
+		conf.rules = newConf.rules
+	}
+	if !reflect.DeepEqual(newConf.Roles, conf.Roles) {
+		LBLogger.Infoln(T("conf.changed-key", "roles"))
+		conf.Roles = newConf.Roles
+	}
+	if !reflect.DeepEqual(newConf.Filter, conf.Filter) {
+		LBLogger.Infoln(T("conf.changed-key", "filter"))
+		conf.Filter, conf.filter = newConf.Filter, newConf.filter
+	}
+	if !reflect.DeepEqual(newConf.Dedup, conf.Dedup) {
+		LBLogger.Infoln(T("conf.changed-key", "dedup"))
+		resize := newConf.Dedup.Size != conf.Dedup.Size || newConf.Dedup.TTL != conf.Dedup.TTL
+		conf.Dedup = newConf.Dedup
+		if resize {
+			defer initDedup()
+		}
+	}
+	if newConf.History != conf.History {
+		LBLogger.Infoln(T("conf.changed", "history", fmt.Sprintf("%+v", conf.History), fmt.Sprintf("%+v", newConf.History)))
+		conf.History = newConf.History
+	}
+	if newConf.InfoCacheTTL != conf.InfoCacheTTL {
+		LBLogger.Infoln(T("conf.changed", "info-cache-ttl", conf.InfoCacheTTL, newConf.InfoCacheTTL))
+		conf.InfoCacheTTL = newConf.InfoCacheTTL
+	}
+	if newConf.FailoverCooldown != conf.FailoverCooldown {
+		LBLogger.Infoln(T("conf.changed", "failover-cooldown", conf.FailoverCooldown, newConf.FailoverCooldown))
+		conf.FailoverCooldown = newConf.FailoverCooldown
+	}
+	if newConf.MaxWorkers != conf.MaxWorkers {
+		LBLogger.Warnln(T("conf.changed-restart", "max-workers", conf.MaxWorkers, newConf.MaxWorkers))
+	}
+	if newConf.UnitTimeout != conf.UnitTimeout {
+		LBLogger.Infoln(T("conf.changed", "unit-timeout", conf.UnitTimeout, newConf.UnitTimeout))
+		conf.UnitTimeout = newConf.UnitTimeout
+	}
+	if newConf.DataDir != conf.DataDir {
+		LBLogger.Warnln(T("conf.changed-restart", "data-dir", conf.DataDir, newConf.DataDir))
+	}
+	conf.Plugins = newConf.Plugins
+	conf.BotInfos = nil
+	setCurConf(conf)
+	applyBotInfos(newConf.BotInfos)
+	applyConfSections(newConf.sections)
+}
+
+func applyBotInfos(bInfos []BotInfo) {
+	oldInfos := make(map[int64]BotInfo)
+	for _, bInfo := range GetBotInfos() {
+		oldInfos[bInfo.BotID] = bInfo
+	}
+	for _, nInfo := range bInfos {
+		oInfo, ok := oldInfos[nInfo.BotID]
+		if !ok {
+			LBLogger.WithField("BotID", nInfo.BotID).Infoln(T("conf.bot-added", nInfo.Name))
+			if err := AddBot(nInfo, false); err != nil {
+				LBLogger.WithField("BotID", nInfo.BotID).Warnln(err)
+				continue
+			}
+			if isStarted {
+				StartBot(nInfo.BotID)
+			}
+			continue
+		}
+		delete(oldInfos, nInfo.BotID)
+		changes, reconnect := diffBotInfo(oInfo, nInfo)
+		if len(changes) == 0 {
+			continue
+		}
+		for _, change := range changes {
+			LBLogger.WithField("BotID", nInfo.BotID).Infoln(T("conf.bot-changed", change))
+		}
+		bCtx, err := getBotCtxByID(nInfo.BotID)
+		if err != nil {
+			continue
+		}
+		updateBotInfo(bCtx, nInfo)
+		if reconnect && bCtx.IsRunning {
+			LBLogger.WithField("BotName", nInfo.Name).Infoln(T("conf.reconnect"))
+			stopBot(bCtx)
+			runBot(bCtx)
+		}
+	}
+	for botID, oInfo := range oldInfos {
+		LBLogger.WithField("BotID", botID).Infoln(T("conf.bot-removed", oInfo.Name))
+		RemoveBot(botID, false)
+	}
+}
+
+// diffBotInfo describes the changes between two bot infos,
+// and reports whether the bot should reconnect to apply them.
+func diffBotInfo(o, n BotInfo) ([]string, bool) {
+	var (
+		changes   []string
+		reconnect bool
+	)
+	if o.Host != n.Host || o.Port != n.Port {
+		changes = append(changes, fmt.Sprintf("address: %v:%v -> %v:%v", o.Host, o.Port, n.Host, n.Port))
+		reconnect = true
+	}
+	if o.Token != n.Token {
+		changes = append(changes, T("conf.token-changed"))
+		reconnect = true
+	}
+	if o.MessageType != n.MessageType {
+		changes = append(changes, fmt.Sprintf("message-type: %v -> %v", o.MessageType, n.MessageType))
+		reconnect = true
+	}
+	if o.Timeout != n.Timeout {
+		changes = append(changes, fmt.Sprintf("time-out: %v -> %v", o.Timeout, n.Timeout))
+		reconnect = true
+	}
+	if o.Name != n.Name {
+		changes = append(changes, fmt.Sprintf("name: %v -> %v", o.Name, n.Name))
+	}
+	if o.MaxMsgLen != n.MaxMsgLen || o.ForwardLines != n.ForwardLines {
+		changes = append(changes, fmt.Sprintf("max-msg-len, forward-lines: %v, %v -> %v, %v", o.MaxMsgLen, o.ForwardLines, n.MaxMsgLen, n.ForwardLines))
+	}
+	if !reflect.DeepEqual(o.Admins, n.Admins) {
+		changes = append(changes, fmt.Sprintf("admins: %v -> %v", o.Admins, n.Admins))
+	}
+	return changes, reconnect
+}
+
+func updateBotInfo(bCtx *BotContext, bInfo BotInfo) {
+	botsLock.Lock()
+	defer botsLock.Unlock()
+	bCtx.info.Store(&bInfo)
+	for i := range Conf.BotInfos {
+		if Conf.BotInfos[i].BotID == bInfo.BotID {
+			Conf.BotInfos[i] = bInfo
+		}
+	}
+}
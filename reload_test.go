@@ -0,0 +1,96 @@
+package luxtbot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffBotInfo(t *testing.T) {
+	o := BotInfo{BotID: 10, Host: "127.0.0.1", Port: 6700, Name: "a", Token: "secret",
+		MessageType: MsgTypeArray, Timeout: 10, Admins: []int64{1}}
+	tests := []struct {
+		name          string
+		change        func(n *BotInfo)
+		wantChanges   []string
+		wantReconnect bool
+	}{
+		{"nothing", func(n *BotInfo) {}, nil, false},
+		{"address", func(n *BotInfo) { n.Port = 6701 }, []string{"address: 127.0.0.1:6700 -> 127.0.0.1:6701"}, true},
+		{"message type", func(n *BotInfo) { n.MessageType = MsgTypeString }, []string{"message-type: array -> string"}, true},
+		{"timeout", func(n *BotInfo) { n.Timeout = 20 }, []string{"time-out: 10 -> 20"}, true},
+		{"name", func(n *BotInfo) { n.Name = "b" }, []string{"name: a -> b"}, false},
+		{"split", func(n *BotInfo) { n.MaxMsgLen = 100 }, []string{"max-msg-len, forward-lines: 0, 0 -> 100, 0"}, false},
+		{"admins", func(n *BotInfo) { n.Admins = []int64{1, 2} }, []string{"admins: [1] -> [1 2]"}, false},
+		{"name and address", func(n *BotInfo) { n.Name, n.Host = "b", "localhost" },
+			[]string{"address: 127.0.0.1:6700 -> localhost:6700", "name: a -> b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := o
+			n.Admins = append([]int64(nil), o.Admins...)
+			tt.change(&n)
+			changes, reconnect := diffBotInfo(o, n)
+			if !reflect.DeepEqual(changes, tt.wantChanges) || reconnect != tt.wantReconnect {
+				t.Errorf("diffBotInfo() = %q, %v, want %q, %v", changes, reconnect, tt.wantChanges, tt.wantReconnect)
+			}
+		})
+	}
+	// the token is never logged
+	n := o
+	n.Token = "other"
+	changes, reconnect := diffBotInfo(o, n)
+	if len(changes) != 1 || !reconnect || strings.Contains(changes[0], "secret") || strings.Contains(changes[0], "other") {
+		t.Errorf("diffBotInfo() of the token = %q, %v", changes, reconnect)
+	}
+}
+
+// useConfBots keeps the bots of the config until the test ends.
+func useConfBots(t *testing.T) {
+	botsLock.Lock()
+	old := Conf.BotInfos
+	botsLock.Unlock()
+	t.Cleanup(func() {
+		botsLock.Lock()
+		Conf.BotInfos = old
+		botsLock.Unlock()
+	})
+}
+
+func TestApplyConf(t *testing.T) {
+	useConf(t, *CurConf())
+	useBots(t, 10, 11)
+	useConfBots(t)
+	newConf := *CurConf()
+	newConf.SAdmins = []int64{1, 2}
+	newConf.History = HistoryConf{Enable: true, Size: 10}
+	newConf.UnitTimeout = CurConf().UnitTimeout + 1
+	// applied after a restart only
+	newConf.MaxWorkers = CurConf().MaxWorkers + 1
+	newConf.DataDir = CurConf().DataDir + "-new"
+	// 10 is renamed, 11 is removed and 12 is added
+	newConf.BotInfos = []BotInfo{
+		{BotID: 10, Name: "renamed"},
+		{BotID: 12, Host: "127.0.0.1", Port: 6712, Name: "12"},
+	}
+	old := *CurConf()
+	applyConf(newConf)
+
+	conf := CurConf()
+	if !reflect.DeepEqual(conf.SAdmins, newConf.SAdmins) || conf.History != newConf.History || conf.UnitTimeout != newConf.UnitTimeout {
+		t.Errorf("the changes are not applied: %+v", conf)
+	}
+	if conf.MaxWorkers != old.MaxWorkers || conf.DataDir != old.DataDir {
+		t.Errorf("the keys applied after a restart are changed: %+v", conf)
+	}
+	if conf.BotInfos != nil {
+		t.Errorf("the bots are kept in the running config: %+v", conf.BotInfos)
+	}
+	var names []string
+	for _, bInfo := range GetBotInfos() {
+		names = append(names, bInfo.Name)
+	}
+	if want := []string{"renamed", "12"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("bots = %q, want %q", names, want)
+	}
+}
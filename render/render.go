@@ -0,0 +1,495 @@
+// Package render draws text with simple markup into a PNG image.
+//
+// No font is embedded, the builtin font only has ASCII glyphs, so CJK
+// text needs a CJK font file such as NotoSansCJK given by Options.FontPath.
+//
+// Markup supported, one per line:
+//
+//	# heading, ## heading, ### heading
+//	**bold** in any text line
+//	| table | row |, a row like |---|---| makes the row above a header
+//	``` starts and ends a code block
+package render
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	DefaultFontSize = 20
+	DefaultWidth    = 800
+	DefaultPadding  = 20
+
+	headingScale = 1.4
+	lineGap      = 6
+	cellPadding  = 8
+)
+
+var (
+	colorText       = color.RGBA{0x20, 0x20, 0x20, 0xff}
+	colorBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	colorCode       = color.RGBA{0xf0, 0xf0, 0xf0, 0xff}
+	colorBorder     = color.RGBA{0xc8, 0xc8, 0xc8, 0xff}
+	colorHeader     = color.RGBA{0xe8, 0xe8, 0xe8, 0xff}
+
+	headingRegex = regexp.MustCompile(`^(#{1,3})\s+(.*)$`)
+	tableSepRe   = regexp.MustCompile(`^:?-{2,}:?$`)
+
+	// ErrMissingGlyph is returned by Render when the font has no glyph
+	// for some of the text, such as CJK text with the builtin font.
+	ErrMissingGlyph = errors.New("render: missing glyph in the font")
+)
+
+type Options struct {
+	// a .ttf, .otf or .ttc font file, CJK text needs a CJK font.
+	// Empty means a builtin font which only has ASCII glyphs.
+	FontPath string
+	FontSize float64
+	// width of the image in pixels
+	Width   int
+	Padding int
+}
+
+// Renderer is safe for concurrent use, the renders share the faces
+// which are not, so they are done one by one.
+type Renderer struct {
+	// guards the faces
+	lock sync.Mutex
+	opts Options
+	// nil for the builtin font
+	fnt     *opentype.Font
+	body    font.Face
+	heading font.Face
+}
+
+// New loads the font and makes a Renderer, zero options use the defaults.
+func New(opts Options) (*Renderer, error) {
+	if opts.FontSize <= 0 {
+		opts.FontSize = DefaultFontSize
+	}
+	if opts.Width <= 0 {
+		opts.Width = DefaultWidth
+	}
+	if opts.Padding <= 0 {
+		opts.Padding = DefaultPadding
+	}
+	r := &Renderer{opts: opts}
+	if opts.FontPath == "" {
+		r.body, r.heading = basicfont.Face7x13, basicfont.Face7x13
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(opts.FontPath)
+	if err != nil {
+		return nil, err
+	}
+	fnt, err := parseFont(data)
+	if err != nil {
+		return nil, err
+	}
+	r.fnt = fnt
+	r.body, err = opentype.NewFace(fnt, &opentype.FaceOptions{Size: opts.FontSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	r.heading, err = opentype.NewFace(fnt, &opentype.FaceOptions{Size: opts.FontSize * headingScale, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseFont parses a single font or the first font of a collection.
+func parseFont(data []byte) (*opentype.Font, error) {
+	fnt, err := opentype.Parse(data)
+	if err == nil {
+		return fnt, nil
+	}
+	coll, cerr := opentype.ParseCollection(data)
+	if cerr != nil {
+		return nil, err
+	}
+	if coll.NumFonts() == 0 {
+		return nil, errors.New("render: empty font collection")
+	}
+	return coll.Font(0)
+}
+
+type span struct {
+	text string
+	bold bool
+}
+
+const (
+	kindText = iota
+	kindHeading
+	kindCode
+	kindTable
+)
+
+type block struct {
+	kind  int
+	spans []span
+	// code lines or table rows
+	lines  []string
+	rows   [][]string
+	header bool
+}
+
+// parse splits the markup into blocks.
+func parse(text string) []block {
+	var (
+		blocks []block
+		code   *block
+		table  *block
+	)
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			if code != nil {
+				blocks = append(blocks, *code)
+				code = nil
+			} else {
+				code = &block{kind: kindCode}
+			}
+			continue
+		}
+		if code != nil {
+			code.lines = append(code.lines, strings.ReplaceAll(line, "\t", "    "))
+			continue
+		}
+		if strings.HasPrefix(trimmed, "|") {
+			if table == nil {
+				table = &block{kind: kindTable}
+			}
+			cells := splitCells(trimmed)
+			if isTableSep(cells) {
+				table.header = len(table.rows) == 1
+				continue
+			}
+			table.rows = append(table.rows, cells)
+			continue
+		}
+		if table != nil {
+			blocks = append(blocks, *table)
+			table = nil
+		}
+		if m := headingRegex.FindStringSubmatch(trimmed); m != nil {
+			blocks = append(blocks, block{kind: kindHeading, spans: []span{{text: stripBold(m[2]), bold: true}}})
+			continue
+		}
+		blocks = append(blocks, block{kind: kindText, spans: parseBold(line)})
+	}
+	if code != nil {
+		blocks = append(blocks, *code)
+	}
+	if table != nil {
+		blocks = append(blocks, *table)
+	}
+	return blocks
+}
+
+func splitCells(line string) []string {
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+func isTableSep(cells []string) bool {
+	for _, cell := range cells {
+		if !tableSepRe.MatchString(cell) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseBold(line string) []span {
+	var spans []span
+	parts := strings.Split(line, "**")
+	// an unpaired ** is kept as text
+	if len(parts)%2 == 0 {
+		return []span{{text: line}}
+	}
+	for i, part := range parts {
+		if part != "" {
+			spans = append(spans, span{text: part, bold: i%2 == 1})
+		}
+	}
+	return spans
+}
+
+func stripBold(s string) string {
+	return strings.ReplaceAll(s, "**", "")
+}
+
+// op is a drawing operation made by layout.
+type op struct {
+	rect  image.Rectangle
+	fill  color.Color
+	text  string
+	face  font.Face
+	bold  bool
+	dot   fixed.Point26_6
+	isBox bool
+}
+
+// Render draws the text into a PNG image, or returns ErrMissingGlyph
+// rather than drawing boxes for the characters not in the font.
+func (r *Renderer) Render(text string) ([]byte, error) {
+	if !r.CanRender(text) {
+		return nil, ErrMissingGlyph
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ops, height := r.layout(parse(text))
+	img := image.NewRGBA(image.Rect(0, 0, r.opts.Width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(colorBackground), image.Point{}, draw.Src)
+	for _, o := range ops {
+		if o.isBox {
+			draw.Draw(img, o.rect, image.NewUniform(o.fill), image.Point{}, draw.Over)
+			continue
+		}
+		d := &font.Drawer{Dst: img, Src: image.NewUniform(colorText), Face: o.face, Dot: o.dot}
+		d.DrawString(o.text)
+		if o.bold {
+			d.Dot = o.dot.Add(fixed.P(1, 0))
+			d.DrawString(o.text)
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), err
+}
+
+// CanRender reports whether the font has glyphs for all of the text.
+func (r *Renderer) CanRender(text string) bool {
+	for _, c := range text {
+		if unicode.IsSpace(c) || !unicode.IsPrint(c) {
+			continue
+		}
+		if r.fnt != nil {
+			if idx, err := r.fnt.GlyphIndex(nil, c); err != nil || idx == 0 {
+				return false
+			}
+		} else if !inBasicFont(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// inBasicFont reports whether the builtin font has the glyph, its
+// GlyphAdvance is ok for any rune.
+func inBasicFont(c rune) bool {
+	for _, rg := range basicfont.Face7x13.Ranges {
+		if c >= rg.Low && c < rg.High {
+			return true
+		}
+	}
+	return false
+}
+
+func lineHeight(face font.Face) int {
+	return face.Metrics().Height.Ceil() + lineGap
+}
+
+// layout computes the drawing operations and the height of the image.
+func (r *Renderer) layout(blocks []block) ([]op, int) {
+	var (
+		ops   []op
+		pad   = r.opts.Padding
+		width = r.opts.Width - 2*pad
+		y     = pad
+	)
+	for _, b := range blocks {
+		switch b.kind {
+		case kindText, kindHeading:
+			face := r.body
+			if b.kind == kindHeading {
+				face = r.heading
+				y += lineGap
+			}
+			for _, line := range wrapSpans(face, b.spans, width) {
+				x := pad
+				ascent := face.Metrics().Ascent.Ceil()
+				for _, s := range line {
+					ops = append(ops, op{text: s.text, face: face, bold: s.bold, dot: fixed.P(x, y+ascent)})
+					x += font.MeasureString(face, s.text).Ceil()
+				}
+				y += lineHeight(face)
+			}
+		case kindCode:
+			top := y
+			y += cellPadding
+			var textOps []op
+			for _, line := range b.lines {
+				for _, wrapped := range wrapSpans(r.body, []span{{text: line}}, width-2*cellPadding) {
+					text := ""
+					if len(wrapped) != 0 {
+						text = wrapped[0].text
+					}
+					textOps = append(textOps, op{text: text, face: r.body, dot: fixed.P(pad+cellPadding, y+r.body.Metrics().Ascent.Ceil())})
+					y += lineHeight(r.body)
+				}
+			}
+			y += cellPadding
+			ops = append(ops, op{isBox: true, fill: colorCode, rect: image.Rect(pad, top, pad+width, y)})
+			ops = append(ops, textOps...)
+			y += lineGap
+		case kindTable:
+			var tableOps []op
+			tableOps, y = r.layoutTable(b, pad, y, width)
+			ops = append(ops, tableOps...)
+			y += lineGap
+		}
+	}
+	return ops, y + pad
+}
+
+func (r *Renderer) layoutTable(b block, x, y, width int) ([]op, int) {
+	cols := 0
+	for _, row := range b.rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	if cols == 0 {
+		return nil, y
+	}
+	colWidths := make([]int, cols)
+	total := 0
+	for _, row := range b.rows {
+		for i, cell := range row {
+			w := font.MeasureString(r.body, cell).Ceil() + 2*cellPadding + 1
+			if w > colWidths[i] {
+				colWidths[i] = w
+			}
+		}
+	}
+	for _, w := range colWidths {
+		total += w
+	}
+	if total > width {
+		for i := range colWidths {
+			colWidths[i] = colWidths[i] * width / total
+		}
+		total = width
+	}
+	var (
+		ops       []op
+		rowHeight = lineHeight(r.body) + cellPadding
+		top       = y
+	)
+	for ri, row := range b.rows {
+		header := b.header && ri == 0
+		if header {
+			ops = append(ops, op{isBox: true, fill: colorHeader, rect: image.Rect(x, y, x+total, y+rowHeight)})
+		}
+		cx := x
+		for ci := 0; ci < cols; ci++ {
+			if ci < len(row) {
+				text := truncate(r.body, row[ci], colWidths[ci]-2*cellPadding)
+				ops = append(ops, op{text: text, face: r.body, bold: header, dot: fixed.P(cx+cellPadding, y+cellPadding/2+lineGap/2+r.body.Metrics().Ascent.Ceil())})
+			}
+			cx += colWidths[ci]
+		}
+		ops = append(ops, op{isBox: true, fill: colorBorder, rect: image.Rect(x, y, x+total, y+1)})
+		y += rowHeight
+	}
+	ops = append(ops, op{isBox: true, fill: colorBorder, rect: image.Rect(x, y, x+total, y+1)})
+	cx := x
+	for _, w := range colWidths {
+		ops = append(ops, op{isBox: true, fill: colorBorder, rect: image.Rect(cx, top, cx+1, y+1)})
+		cx += w
+	}
+	ops = append(ops, op{isBox: true, fill: colorBorder, rect: image.Rect(cx-1, top, cx, y+1)})
+	return ops, y + 1
+}
+
+// truncate cuts the text to fit the width, ending with "…".
+func truncate(face font.Face, text string, width int) string {
+	if font.MeasureString(face, text).Ceil() <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		s := string(runes) + "…"
+		if font.MeasureString(face, s).Ceil() <= width {
+			return s
+		}
+	}
+	return ""
+}
+
+// wrapSpans breaks the spans into lines no wider than width,
+// at a space if possible, or else between any characters.
+func wrapSpans(face font.Face, spans []span, width int) [][]span {
+	type glyph struct {
+		r    rune
+		bold bool
+		w    int
+	}
+	var glyphs []glyph
+	for _, s := range spans {
+		for _, c := range s.text {
+			adv, _ := face.GlyphAdvance(c)
+			glyphs = append(glyphs, glyph{c, s.bold, adv.Ceil()})
+		}
+	}
+	toSpans := func(gs []glyph) []span {
+		var result []span
+		for _, g := range gs {
+			if n := len(result); n != 0 && result[n-1].bold == g.bold {
+				result[n-1].text += string(g.r)
+				continue
+			}
+			result = append(result, span{text: string(g.r), bold: g.bold})
+		}
+		return result
+	}
+	var (
+		lines [][]span
+		start int
+		lineW int
+		space = -1
+	)
+	for i := 0; i < len(glyphs); i++ {
+		g := glyphs[i]
+		if lineW+g.w > width && i > start {
+			end := i
+			if space > start {
+				end = space + 1
+			}
+			lines = append(lines, toSpans(glyphs[start:end]))
+			start, lineW, space = end, 0, -1
+			i = end - 1
+			continue
+		}
+		if unicode.IsSpace(g.r) {
+			space = i
+		}
+		lineW += g.w
+	}
+	lines = append(lines, toSpans(glyphs[start:]))
+	return lines
+}
@@ -0,0 +1,66 @@
+package render
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func newTestRenderer(t *testing.T) *Renderer {
+	path := filepath.Join(t.TempDir(), "goregular.ttf")
+	if err := ioutil.WriteFile(path, goregular.TTF, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(Options{FontPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRenderConcurrent(t *testing.T) {
+	r := newTestRenderer(t)
+	text := "# heading\n**bold** text\n| a | b |\n|---|---|\n| 1 | 2 |\n```\ncode\n```"
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := r.Render(text); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRenderMissingGlyph(t *testing.T) {
+	builtin, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		r    *Renderer
+		text string
+		want error
+	}{
+		{"builtin ascii", builtin, "hello **world**", nil},
+		{"builtin cjk", builtin, "你好", ErrMissingGlyph},
+		{"font ascii", newTestRenderer(t), "hello", nil},
+		{"font cjk", newTestRenderer(t), "你好", ErrMissingGlyph},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.r.Render(tt.text); err != tt.want {
+				t.Errorf("Render(%q) = %v, want %v", tt.text, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package luxtbot
+
+import (
+	"errors"
+)
+
+type replyConf struct {
+	quote    bool
+	atSender bool
+	temp     bool
+}
+
+// ReplyOption changes how Event.Reply sends the message.
+type ReplyOption func(rc *replyConf)
+
+// WithQuote quotes the message replied to by a reply segment.
+func WithQuote() ReplyOption {
+	return func(rc *replyConf) {
+		rc.quote = true
+	}
+}
+
+// WithAtSender ats the sender when replying in a group.
+func WithAtSender() ReplyOption {
+	return func(rc *replyConf) {
+		rc.atSender = true
+	}
+}
+
+// WithTempSession replies a group message privately to the sender by temp session.
+func WithTempSession() ReplyOption {
+	return func(rc *replyConf) {
+		rc.temp = true
+	}
+}
+
+// Reply sends a message to where the event comes from, and returns the
+// message_id of the message sent. Events other than message events are
+// replied to the group if there is a group_id, or else to the user.
+func (e *Event) Reply(bInfo BotInfo, msg MsgBuilder, opts ...ReplyOption) (int, error) {
+	api, err := e.replyApi(msg, opts...)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := api.DoWithResp(bInfo.BotID, 0)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Data.MessageID, nil
+}
+
+// replyApi makes the api sending the message to where the event comes from.
+func (e *Event) replyApi(msg MsgBuilder, opts ...ReplyOption) (ApiPost, error) {
+	var rc replyConf
+	for _, opt := range opts {
+		opt(&rc)
+	}
+	data, err := msg.GetMsg()
+	if err != nil {
+		return ApiPost{}, err
+	}
+	var prefix []MsgSeg
+	if rc.quote && e.MessageID != 0 {
+		prefix = append(prefix, replySeg(e.MessageID))
+	}
+	var api ApiPost
+	msgType := e.MessageType
+	if e.PostType != MessageEvent {
+		msgType = MsgTypePrivate
+		if e.GroupID != 0 {
+			msgType = MsgTypeGroup
+		}
+	}
+	switch {
+	case msgType == MsgTypePrivate:
+		api = makeApi(PrivateMsgAction, &PrivateMsg{
+			UserID:  e.UserID,
+			Message: prependSegs(data, prefix...),
+		})
+	case msgType == MsgTypeGroup && rc.temp:
+		api = makeApi(PrivateMsgAction, &PrivateMsg{
+			UserID:  e.UserID,
+			GroupID: e.GroupID,
+			Message: prependSegs(data, prefix...),
+		})
+	case msgType == MsgTypeGroup:
+		if rc.atSender && e.UserID != 0 {
+			prefix = append(prefix, atSeg(e.UserID), textSeg(" "))
+		}
+		api = makeApi(GroupMsgAction, &GroupMsg{
+			GroupID: e.GroupID,
+			Message: prependSegs(data, prefix...),
+		})
+	default:
+		return api, errors.New(T("err.reply-type", e.MessageType))
+	}
+	return api, nil
+}
+
+// prependSegs puts the segments before a message built by MsgBuilder.
+func prependSegs(data interface{}, segs ...MsgSeg) interface{} {
+	if len(segs) == 0 {
+		return data
+	}
+	switch msg := data.(type) {
+	case string:
+		return EncodeMsgSegs(segs) + msg
+	case Message:
+		return Message(segs).Concat(msg)
+	case []MsgSeg:
+		return Message(segs).Concat(msg)
+	}
+	return data
+}
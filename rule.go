@@ -65,7 +65,7 @@ var (
 
     // 发送者是否是超级管理员
     IsSAdmin = func(e *Event, bInfo BotInfo) bool {
-        for _, admin := range Conf.SAdmins {
+        for _, admin := range CurConf().SAdmins {
             if e.UserID == admin {
                 return true
             }
@@ -80,4 +80,42 @@ var (
     IsPrivateMsg = func(e *Event, bInfo BotInfo) bool {
         return e.MessageType == MsgTypePrivate
     }
-)
\ No newline at end of file
+)
+
+// Judge makes the rule a Judge, so that it could be nested in other rules.
+func (r *Rule) Judge() Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		return r.CheckRules(e, bInfo)
+	}
+}
+
+// Not matches if j does not match.
+func Not(j Judge) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		return !j(e, bInfo)
+	}
+}
+
+// All matches if all of js match, it matches if js is empty.
+func All(js ...Judge) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		for _, j := range js {
+			if !j(e, bInfo) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Any matches if one of js matches, it does not match if js is empty.
+func Any(js ...Judge) Judge {
+	return func(e *Event, bInfo BotInfo) bool {
+		for _, j := range js {
+			if j(e, bInfo) {
+				return true
+			}
+		}
+		return false
+	}
+}
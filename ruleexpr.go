@@ -0,0 +1,428 @@
+package luxtbot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A rule expression is made of conditions joined by and, or, not and parentheses:
+//
+//	group in [123, 456] and not user in blacklist
+//	to_me or (private and text startswith "查询")
+//
+// Conditions:
+//
+//	group, user      in [ids] | in <list name> | == id | != id
+//	role, sub_type   in [values] | == value | != value
+//	type             message type, in / == / != private or group
+//	text             contains | startswith | endswith | == | in | matches "regexp"
+//	time             in "08:00-22:00"
+//	to_me, admin, s_admin, private, group_msg
+//
+// The list names refer to the lists section of the config.
+
+const (
+	tokIdent = iota
+	tokNum
+	tokStr
+	tokPunct
+	tokEOF
+)
+
+type ruleToken struct {
+	kind int
+	val  string
+	pos  int
+}
+
+type ruleParser struct {
+	toks  []ruleToken
+	pos   int
+	lists map[string][]int64
+}
+
+var ruleAtoms = map[string]Judge{
+	"to_me":     ToMe(),
+	"admin":     IsAdmin,
+	"s_admin":   IsSAdmin,
+	"private":   IsPrivateMsg,
+	"group_msg": IsGroupMsg,
+}
+
+// ParseRuleExpr parses a rule expression into a Judge,
+// lists are the named id lists which could be used after in.
+func ParseRuleExpr(expr string, lists map[string][]int64) (Judge, error) {
+	toks, err := lexRuleExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &ruleParser{toks: toks, lists: lists}
+	j, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.unexpected(tok)
+	}
+	return j, nil
+}
+
+func lexRuleExpr(expr string) ([]ruleToken, error) {
+	var toks []ruleToken
+	rs := []rune(expr)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(rs) && (rs[i] == '_' || unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i])) {
+				i++
+			}
+			toks = append(toks, ruleToken{tokIdent, string(rs[start:i]), start})
+		case unicode.IsDigit(r) || r == '-':
+			start := i
+			i++
+			for i < len(rs) && unicode.IsDigit(rs[i]) {
+				i++
+			}
+			toks = append(toks, ruleToken{tokNum, string(rs[start:i]), start})
+		case r == '"':
+			start := i
+			for i++; i < len(rs) && rs[i] != '"'; i++ {
+				if rs[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(rs) {
+				return nil, errors.New(T("rule.eof"))
+			}
+			i++
+			val, err := strconv.Unquote(string(rs[start:i]))
+			if err != nil {
+				return nil, errors.New(T("rule.bad-value", string(rs[start:i])))
+			}
+			toks = append(toks, ruleToken{tokStr, val, start})
+		case strings.ContainsRune("()[],", r):
+			toks = append(toks, ruleToken{tokPunct, string(r), i})
+			i++
+		case (r == '=' || r == '!') && i+1 < len(rs) && rs[i+1] == '=':
+			toks = append(toks, ruleToken{tokPunct, string(rs[i : i+2]), i})
+			i += 2
+		default:
+			return nil, errors.New(T("rule.unexpected", string(r), i))
+		}
+	}
+	return append(toks, ruleToken{tokEOF, "", len(rs)}), nil
+}
+
+func (p *ruleParser) peek() ruleToken {
+	return p.toks[p.pos]
+}
+
+func (p *ruleParser) next() ruleToken {
+	tok := p.toks[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *ruleParser) unexpected(tok ruleToken) error {
+	if tok.kind == tokEOF {
+		return errors.New(T("rule.eof"))
+	}
+	return errors.New(T("rule.unexpected", tok.val, tok.pos))
+}
+
+// isWord reports whether the next token is the keyword.
+func (p *ruleParser) isWord(word string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && tok.val == word
+}
+
+func (p *ruleParser) expect(punct string) error {
+	if tok := p.next(); tok.kind != tokPunct || tok.val != punct {
+		return p.unexpected(tok)
+	}
+	return nil
+}
+
+func (p *ruleParser) parseOr() (Judge, error) {
+	j, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	js := []Judge{j}
+	for p.isWord("or") {
+		p.next()
+		j, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		js = append(js, j)
+	}
+	if len(js) == 1 {
+		return js[0], nil
+	}
+	return Any(js...), nil
+}
+
+func (p *ruleParser) parseAnd() (Judge, error) {
+	j, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	js := []Judge{j}
+	for p.isWord("and") {
+		p.next()
+		j, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		js = append(js, j)
+	}
+	if len(js) == 1 {
+		return js[0], nil
+	}
+	return All(js...), nil
+}
+
+func (p *ruleParser) parseUnary() (Judge, error) {
+	if p.isWord("not") {
+		p.next()
+		j, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(j), nil
+	}
+	if tok := p.peek(); tok.kind == tokPunct && tok.val == "(" {
+		p.next()
+		j, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return j, p.expect(")")
+	}
+	return p.parseCond()
+}
+
+func (p *ruleParser) parseCond() (Judge, error) {
+	tok := p.next()
+	if tok.kind != tokIdent {
+		return nil, p.unexpected(tok)
+	}
+	field := tok.val
+	if j, ok := ruleAtoms[field]; ok {
+		return j, nil
+	}
+	op := p.next()
+	if op.kind != tokIdent && op.kind != tokPunct {
+		return nil, p.unexpected(op)
+	}
+	switch field {
+	case "group", "user":
+		return p.parseIDCond(field, op)
+	case "role", "sub_type", "type", "text", "time":
+		return p.parseStrCond(field, op)
+	}
+	return nil, errors.New(T("rule.unknown-field", field))
+}
+
+// parseValues parses a value, a list of values or a list name.
+func (p *ruleParser) parseValues(allowList bool) ([]ruleToken, error) {
+	tok := p.next()
+	switch {
+	case tok.kind == tokNum || tok.kind == tokStr:
+		return []ruleToken{tok}, nil
+	case tok.kind == tokIdent && allowList:
+		ids, ok := p.lists[tok.val]
+		if !ok {
+			return nil, errors.New(T("rule.unknown-list", tok.val))
+		}
+		vals := make([]ruleToken, 0, len(ids))
+		for _, id := range ids {
+			vals = append(vals, ruleToken{tokNum, strconv.FormatInt(id, 10), tok.pos})
+		}
+		return vals, nil
+	case tok.kind == tokIdent:
+		// bare words like owner or group
+		return []ruleToken{{tokStr, tok.val, tok.pos}}, nil
+	case tok.kind == tokPunct && tok.val == "[":
+		var vals []ruleToken
+		for {
+			val := p.next()
+			switch {
+			case val.kind == tokNum || val.kind == tokStr:
+			case val.kind == tokIdent:
+				val.kind = tokStr
+			case val.kind == tokPunct && val.val == "]" && len(vals) == 0:
+				return vals, nil
+			default:
+				return nil, p.unexpected(val)
+			}
+			vals = append(vals, val)
+			sep := p.next()
+			if sep.kind == tokPunct && sep.val == "]" {
+				return vals, nil
+			}
+			if sep.kind != tokPunct || sep.val != "," {
+				return nil, p.unexpected(sep)
+			}
+		}
+	}
+	return nil, p.unexpected(tok)
+}
+
+func (p *ruleParser) parseIDCond(field string, op ruleToken) (Judge, error) {
+	if op.val != "in" && op.val != "==" && op.val != "!=" {
+		return nil, errors.New(T("rule.bad-op", field, op.val))
+	}
+	vals, err := p.parseValues(op.val == "in")
+	if err != nil {
+		return nil, err
+	}
+	if op.val != "in" && len(vals) != 1 {
+		return nil, errors.New(T("rule.bad-op", field, op.val))
+	}
+	ids := make([]int64, 0, len(vals))
+	for _, val := range vals {
+		id, err := strconv.ParseInt(val.val, 10, 64)
+		if err != nil || val.kind != tokNum {
+			return nil, errors.New(T("rule.bad-value", val.val))
+		}
+		ids = append(ids, id)
+	}
+	var j Judge
+	if field == "group" {
+		j = InGroups(ids...)
+	} else {
+		j = FromUsers(ids...)
+	}
+	if op.val == "!=" {
+		return Not(j), nil
+	}
+	return j, nil
+}
+
+func (p *ruleParser) parseStrCond(field string, op ruleToken) (Judge, error) {
+	vals, err := p.parseValues(false)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, 0, len(vals))
+	for _, val := range vals {
+		strs = append(strs, val.val)
+	}
+	if len(strs) == 0 {
+		return nil, errors.New(T("rule.bad-value", "[]"))
+	}
+	if op.val != "in" && len(strs) != 1 {
+		return nil, errors.New(T("rule.bad-op", field, op.val))
+	}
+	switch field {
+	case "text":
+		return textCond(op.val, strs)
+	case "time":
+		if op.val != "in" {
+			return nil, errors.New(T("rule.bad-op", field, op.val))
+		}
+		return timeCond(strs[0])
+	}
+	var j Judge
+	switch field {
+	case "role":
+		j = SenderRole(strs...)
+	case "sub_type":
+		j = SubType(strs...)
+	case "type":
+		j = func(e *Event, bInfo BotInfo) bool {
+			return containsStr(strs, e.MessageType)
+		}
+	}
+	switch op.val {
+	case "in", "==":
+		return j, nil
+	case "!=":
+		return Not(j), nil
+	}
+	return nil, errors.New(T("rule.bad-op", field, op.val))
+}
+
+func textCond(op string, strs []string) (Judge, error) {
+	switch op {
+	case "contains":
+		return Keyword(strs...), nil
+	case "startswith":
+		return StartsWith(strs...), nil
+	case "endswith":
+		return EndsWith(strs...), nil
+	case "==", "in":
+		return FullMatch(strs...), nil
+	case "!=":
+		return Not(FullMatch(strs...)), nil
+	case "matches":
+		j, err := RegexE(strs[0])
+		if err != nil {
+			return nil, errors.New(T("rule.bad-value", err))
+		}
+		return j, nil
+	}
+	return nil, errors.New(T("rule.bad-op", "text", op))
+}
+
+// timeCond parses "08:00-22:00".
+func timeCond(window string) (Judge, error) {
+	clocks := strings.Split(window, "-")
+	if len(clocks) != 2 {
+		return nil, errors.New(T("rule.bad-value", window))
+	}
+	j, err := TimeBetweenE(strings.TrimSpace(clocks[0]), strings.TrimSpace(clocks[1]))
+	if err != nil {
+		return nil, errors.New(T("rule.bad-value", window))
+	}
+	return j, nil
+}
+
+func containsStr(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConfRules parses the rules section, keyed by the plugin name
+// or <plugin name>.<unit name>.
+func parseConfRules(conf *Config) (map[string]Judge, error) {
+	rules := make(map[string]Judge, len(conf.Rules))
+	for name, expr := range conf.Rules {
+		j, err := ParseRuleExpr(expr, conf.Lists)
+		if err != nil {
+			return nil, fmt.Errorf("rules.%v: %v", name, err)
+		}
+		rules[name] = j
+	}
+	return rules, nil
+}
+
+// checkConfRules checks the rules in the config for the plugin and the unit.
+func checkConfRules(plg *Plugin, unit string, e *Event, bInfo BotInfo) bool {
+	rules := CurConf().rules
+	if j, ok := rules[plg.Name]; ok && !j(e, bInfo) {
+		return false
+	}
+	if unit == "" {
+		return true
+	}
+	if j, ok := rules[plg.Name+"."+unit]; ok && !j(e, bInfo) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package luxtbot
+
+import (
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestParseRuleExpr(t *testing.T) {
+	lists := map[string][]int64{"blacklist": {7, 8}}
+	groupMsg := &Event{PostType: MessageEvent, MessageType: MsgTypeGroup, GroupID: 123, UserID: 1,
+		Sender: Sender{Role: RoleAdmin}, Message: Message{textSeg("查询 天气")}}
+	privateMsg := &Event{PostType: MessageEvent, MessageType: MsgTypePrivate, UserID: 7, Message: Message{textSeg("hi")}}
+	tests := []struct {
+		expr        string
+		wantGroup   bool
+		wantPrivate bool
+	}{
+		{"group in [123, 456]", true, false},
+		{"group == 123", true, false},
+		{"group != 123", false, true},
+		{"user in blacklist", false, true},
+		{"not user in blacklist", true, false},
+		{"user in []", false, false},
+		{"role == admin", true, false},
+		{`role in [owner, "admin"]`, true, false},
+		{"type == private", false, true},
+		{"type != private", true, false},
+		{`text startswith "查询"`, true, false},
+		{`text contains "天"`, true, false},
+		{`text endswith "hi"`, false, true},
+		{`text == "hi"`, false, true},
+		{`text matches "^查.*气$"`, true, false},
+		{"private or group_msg", true, true},
+		{"to_me", false, true},
+		{`group in [123] and (text startswith "查询" or user == 7)`, true, false},
+		{"not (private or group == 123)", false, false},
+		{"private and not user in blacklist or group == 123", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			j, err := ParseRuleExpr(tt.expr, lists)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := j(groupMsg, BotInfo{BotID: 10}); got != tt.wantGroup {
+				t.Errorf("group message: %v, want %v", got, tt.wantGroup)
+			}
+			if got := j(privateMsg, BotInfo{BotID: 10}); got != tt.wantPrivate {
+				t.Errorf("private message: %v, want %v", got, tt.wantPrivate)
+			}
+		})
+	}
+}
+
+func TestParseRuleExprMalformed(t *testing.T) {
+	exprs := []string{
+		"",
+		"group",
+		"group in",
+		"group in [123",
+		"group in [123,]",
+		"group in [123 456]",
+		"group in nolist",
+		`group == "123"`,
+		"group == [1, 2]",
+		"group contains 1",
+		"user in [abc]",
+		"unknown == 1",
+		"(private",
+		"private)",
+		"private and",
+		"not",
+		"private group_msg",
+		`text startswith "unclosed`,
+		`text matches "("`,
+		`text like "a"`,
+		"role in []",
+		`time in "8-22"`,
+		`time == "08:00-22:00"`,
+		`time in "25:00-26:00"`,
+		"group == 1 @",
+	}
+	for _, expr := range exprs {
+		if _, err := ParseRuleExpr(expr, nil); err == nil {
+			t.Errorf("ParseRuleExpr(%q) succeeded", expr)
+		}
+	}
+}
+
+// a rule of a unit is one flat key, nested keys are rejected
+func TestConfRulesKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		yml  string
+		want []string
+		fail bool
+	}{
+		{"plugin and unit", "rules:\n  p: private\n  \"p.u\": group_msg\n", []string{"p", "p.u"}, false},
+		{"nested", "rules:\n  p:\n    u: private\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conf Config
+			err := yaml.UnmarshalStrict([]byte(tt.yml), &conf)
+			if tt.fail {
+				if err == nil {
+					t.Error("nested rules were decoded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules, err := parseConfRules(&conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range tt.want {
+				if rules[key] == nil {
+					t.Errorf("no rule of %q in %v", key, rules)
+				}
+			}
+		})
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"runtime/debug"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ws "github.com/gorilla/websocket"
@@ -45,7 +48,7 @@ func InitPluginList() {
 		return PluginList[l].ID < PluginList[r].ID
 	})
 	for _, plg := range PluginList {
-		LBLogger.Infoln("启动插件：", plg.ID, plg.Name)
+		LBLogger.Infoln(T("plugin.start", plg.ID, plg.Name))
 	}
 }
 
@@ -58,25 +61,71 @@ func InitBackenPlugin() {
 }
 
 func InitBotCtxs() {
-	for _, bot := range Conf.BotInfos {
-		bCtx := BotContext{
-			Conn:      nil,
-			CloseLock: new(sync.Mutex),
-			OutChan:   make(chan ApiPost, 10),
-			FlagChan:  make(chan byte, 3),
-			CloseChan: make(chan byte, 1),
-			IsReady:   false,
-			BotInfo:   &bot,
-		}
-		bots = append(bots, bCtx)
+	botsLock.Lock()
+	defer botsLock.Unlock()
+	for i := range Conf.BotInfos {
+		bInfo := Conf.BotInfos[i]
+		bots = append(bots, newBotCtx(&bInfo))
 	}
 }
 
+func newBotCtx(bInfo *BotInfo) *BotContext {
+	bCtx := &BotContext{
+		Conn:      nil,
+		CloseLock: new(sync.Mutex),
+		OutChan:   make(chan ApiPost, 10),
+		FlagChan:  make(chan byte, 3),
+		CloseChan: make(chan byte),
+		StopChan:  make(chan byte),
+		IsReady:   false,
+		IsRunning: false,
+		BotInfo:   bInfo,
+		cache:     newInfoCache(),
+	}
+	bCtx.info.Store(bInfo)
+	return bCtx
+}
+
+// Info returns the current info of the bot, which must not be modified.
+func (bCtx *BotContext) Info() *BotInfo {
+	return bCtx.info.Load().(*BotInfo)
+}
+
 func RunBots() {
-	for i := range bots {
-		go connCQServer(&bots[i], ReconnTimes)
-		go heartCheck(&bots[i])
+	botsLock.RLock()
+	defer botsLock.RUnlock()
+	for _, bCtx := range bots {
+		runBot(bCtx)
+	}
+}
+
+func runBot(bCtx *BotContext) {
+	bCtx.CloseLock.Lock()
+	if bCtx.IsRunning {
+		bCtx.CloseLock.Unlock()
+		return
 	}
+	bCtx.StopChan = make(chan byte)
+	bCtx.IsRunning = true
+	stop := bCtx.StopChan
+	bCtx.CloseLock.Unlock()
+	runBotStateHooks(*bCtx.Info(), BotStarted)
+	go connCQServer(bCtx, ReconnTimes)
+	go heartCheck(bCtx, stop)
+}
+
+// stopBot closes the connection and ends all of the goroutines of the bot.
+func stopBot(bCtx *BotContext) {
+	bCtx.CloseLock.Lock()
+	if !bCtx.IsRunning {
+		bCtx.CloseLock.Unlock()
+		return
+	}
+	bCtx.IsRunning = false
+	close(bCtx.StopChan)
+	bCtx.CloseLock.Unlock()
+	closeConn(bCtx)
+	runBotStateHooks(*bCtx.Info(), BotStopped)
 }
 
 func RunEventDispatcher() {
@@ -87,34 +136,39 @@ func RunEventDispatcher() {
 			switch e.PostType {
 			case MessageEvent:
 				for _, mp := range MsgChain {
-					if !mp.Plg.Enable || !mp.Rule.CheckRules(e, *bCtx.BotInfo) {
+					mp, ue := mp, *e
+					if !matchUnit(mp.Plg, mp.Rule, mp.Name, &ue, *bCtx.Info()) {
 						continue
 					}
-					go mp.Process(e, *bCtx.BotInfo)
+					runUnit(mp.Plg, mp.Name, mp.Timeout, &ue, bCtx, nil, mp.handler())
 				}
 				cmd, qq := parseCmd(e)
 				if cmd == "" {
 					continue
 				}
 				for _, cp := range CmdChain {
-					if !cp.Plg.Enable || !cp.matchCmd(cmd, qq, *&bCtx.BotInfo.BotID) || !cp.Rule.CheckRules(e, *bCtx.BotInfo) {
+					cp, ue := cp, *e
+					if !cp.matchCmd(cmd, qq, *&bCtx.Info().BotID) || !matchUnit(cp.Plg, cp.Rule, cp.unitName(), &ue, *bCtx.Info()) {
 						continue
 					}
-					go cp.Process(e, parseParams(e), *bCtx.BotInfo)
+					params := parseParams(e)
+					runUnit(cp.Plg, cp.unitName(), cp.Timeout, &ue, bCtx, params, cp.handler())
 				}
 			case NoticeEvent:
 				for _, np := range NoticeChain {
-					if !np.Plg.Enable || !np.Rule.CheckRules(e, *bCtx.BotInfo) {
+					np, ue := np, *e
+					if !matchUnit(np.Plg, np.Rule, np.Name, &ue, *bCtx.Info()) {
 						continue
 					}
-					go np.Process(e, *bCtx.BotInfo)
+					runUnit(np.Plg, np.Name, np.Timeout, &ue, bCtx, nil, np.handler())
 				}
 			case RequestEvent:
-				for _, mp := range MsgChain {
-					if !mp.Plg.Enable || !mp.Rule.CheckRules(e, *bCtx.BotInfo) {
+				for _, rp := range RequestChain {
+					rp, ue := rp, *e
+					if !matchUnit(rp.Plg, rp.Rule, rp.Name, &ue, *bCtx.Info()) {
 						continue
 					}
-					go mp.Process(e, *bCtx.BotInfo)
+					runUnit(rp.Plg, rp.Name, rp.Timeout, &ue, bCtx, nil, rp.handler())
 				}
 			case MetaEvent:
 				processMateEvent(e, bCtx)
@@ -123,12 +177,33 @@ func RunEventDispatcher() {
 	}()
 }
 
+// matchUnit checks whether a unit should process the event: the plugin is
+// enabled, the rule of the unit and the rules in the config match, and the
+// sender has the permission of the unit. A rule panicking does not match.
+func matchUnit(plg *Plugin, rule *Rule, unit string, e *Event, bInfo BotInfo) (matched bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			if plg.stats != nil {
+				atomic.AddUint64(&plg.stats.panics, 1)
+			}
+			LBLogger.WithField("PluginID", plg.ID).WithField("Unit", unit).Errorf("%v\n%s", T("exec.rule-panic", err), debug.Stack())
+			matched = false
+		}
+	}()
+	return plg.Enable && rule.CheckRules(e, bInfo) && checkConfRules(plg, unit, e, bInfo) && checkPerm(plg, unit, e, bInfo)
+}
+
 func RunRespDispatcher(poolSize int) {
-	go func() {
+	callBackLock.Lock()
+	if callBackPool == nil {
 		callBackPool = make(map[string]EchoCallback, poolSize)
+	}
+	callBackLock.Unlock()
+	go func() {
 		for {
 			select {
 			case respCtx := <-cqRespChan:
+				runApiRespHooks(respCtx.resp, *respCtx.bCtx.Info())
 				if respCtx.resp.Echo != "" {
 					doEchoCallback(respCtx.resp, respCtx.bCtx)
 				}
@@ -139,23 +214,45 @@ func RunRespDispatcher(poolSize int) {
 
 type EchoCallback func(apiResp *ApiResp, bInfo BotInfo)
 
-var callBackPool map[string]EchoCallback
+var (
+	callBackPool = make(map[string]EchoCallback)
+	callBackLock sync.Mutex
+)
 
 func AddEchoCallback(echo string, callback EchoCallback) {
+	callBackLock.Lock()
+	defer callBackLock.Unlock()
 	callBackPool[echo] = callback
 }
 
+func removeEchoCallback(echo string) {
+	callBackLock.Lock()
+	defer callBackLock.Unlock()
+	delete(callBackPool, echo)
+}
+
 func doEchoCallback(apiResp *ApiResp, bCtx *BotContext) {
 	if len(apiResp.Echo) == 0 {
 		return
 	}
-	callback := callBackPool[apiResp.Echo]
+	callback := takeEchoCallback(apiResp.Echo)
 	if callback == nil {
-		LBLogger.WithField("BotName", bCtx.BotInfo.Name).WithField("Echo", apiResp.Echo).Infoln("找不到api回复回调函数。")
+		if strings.HasPrefix(apiResp.Echo, historyEchoPrefix) {
+			return
+		}
+		LBLogger.WithField("BotName", bCtx.Info().Name).WithField("Echo", apiResp.Echo).Infoln(T("bot.no-callback"))
 		return
 	}
-	go callback(apiResp, *bCtx.BotInfo)
-	callBackPool[apiResp.Echo] = nil
+	go callback(apiResp, *bCtx.Info())
+}
+
+// takeEchoCallback removes the callback of the echo and returns it.
+func takeEchoCallback(echo string) EchoCallback {
+	callBackLock.Lock()
+	defer callBackLock.Unlock()
+	callback := callBackPool[echo]
+	delete(callBackPool, echo)
+	return callback
 }
 
 func RunBackenPlugin() {
@@ -164,39 +261,53 @@ func RunBackenPlugin() {
 			LBLogger.WithField("Plugin", bp.Plg.Name).Debugln("the start func of backen plugin is nil!")
 			continue
 		}
-		go bp.Start(Conf.BotInfos)
+		runBacken(bp, GetBotInfos())
 	}
 }
 
 func connCQServer(bCtx *BotContext, try int) {
 	header := make(http.Header)
-	header.Add("Authorization", TokenPrefix + bCtx.BotInfo.Token)
+	header.Add("Authorization", TokenPrefix+bCtx.Info().Token)
 	header.Add("Content-Type", "application/json; charset=utf-8")
 	var (
 		conn *ws.Conn
 		err  error
 	)
+	stop := bCtx.StopChan
 	for i := 0; i < try; i++ {
-		LBLogger.WithField("BotName", bCtx.BotInfo.Name).Printf("尝试连接第%v次", i+1)
-		conn, _, err = ws.DefaultDialer.Dial("ws://"+bCtx.BotInfo.Host+":"+strconv.Itoa(bCtx.BotInfo.Port), header)
+		LBLogger.WithField("BotName", bCtx.Info().Name).Println(T("bot.connecting", i+1))
+		conn, _, err = ws.DefaultDialer.Dial("ws://"+bCtx.Info().Host+":"+strconv.Itoa(bCtx.Info().Port), header)
 		if err != nil {
-			LBLogger.WithField("BotName", bCtx.BotInfo.Name).Warnln("连接CQ server 失败。将在3秒后重试。")
-			time.Sleep(time.Second * 3)
+			LBLogger.WithField("BotName", bCtx.Info().Name).Warnln(T("bot.connect-fail"))
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Second * 3):
+			}
 			continue
 		}
+		bCtx.CloseLock.Lock()
+		if !bCtx.IsRunning {
+			bCtx.CloseLock.Unlock()
+			conn.Close()
+			return
+		}
 		bCtx.Conn = conn
+		bCtx.CloseChan = make(chan byte)
 		bCtx.IsReady = true
+		bCtx.CloseLock.Unlock()
 		for _, hook := range onConnectChain {
-			err = hook(*bCtx.BotInfo)
+			err = hook(*bCtx.Info())
 			if err != nil {
-				LBLogger.WithField("BotName", bCtx.BotInfo.Name).Infoln(err)
+				LBLogger.WithField("BotName", bCtx.Info().Name).Infoln(err)
 				closeConn(bCtx)
 				return
 			}
 		}
-		LBLogger.WithField("BotName", bCtx.BotInfo.Name).Infoln("Bot已经上线。")
-		go receiveData(bCtx)
-		go sendData(bCtx)
+		LBLogger.WithField("BotName", bCtx.Info().Name).Infoln(T("bot.online"))
+		runBotStateHooks(*bCtx.Info(), BotOnline)
+		go receiveData(bCtx, conn, bCtx.CloseChan)
+		go sendData(bCtx, conn, bCtx.CloseChan)
 		break
 	}
 }
@@ -206,30 +317,37 @@ const (
 	OffHeartCheck  = 0
 )
 
-func heartCheck(bCtx *BotContext) {
-	if bCtx.BotInfo.Timeout == OffHeartCheck {
+func heartCheck(bCtx *BotContext, stop chan byte) {
+	if bCtx.Info().Timeout == OffHeartCheck {
 		return
 	}
-	timeout := bCtx.BotInfo.Timeout
+	timeout := bCtx.Info().Timeout
 	if timeout < DefaultTimeout {
 		timeout = DefaultTimeout
 	}
-	LBLogger.WithField("BotName", bCtx.BotInfo.Name).Infoln("将在15秒之后开启心跳检测，超时时间为：", timeout)
-	time.Sleep(time.Second * 15)
+	LBLogger.WithField("BotName", bCtx.Info().Name).Infoln(T("bot.heart-start", timeout))
+	select {
+	case <-stop:
+		return
+	case <-time.After(time.Second * 15):
+	}
 	for {
 		select {
+		case <-stop:
+			return
 		case flag := <-bCtx.FlagChan:
 			{
 				if flag == heartOn {
 					continue
 				} else if flag == idMismatch {
-					closeConn(bCtx)
-					LBLogger.WithField("BotName", bCtx.BotInfo.Name).Warnln("已禁用该Bot")
+					// stopped rather than closed, so that StartBot works after the config is fixed
+					stopBot(bCtx)
+					LBLogger.WithField("BotName", bCtx.Info().Name).Warnln(T("bot.disabled"))
 					return
 				}
 			}
 		case <-time.After(time.Second * time.Duration(timeout)):
-			LBLogger.WithField("BotName", bCtx.BotInfo.Name).Infoln("Bot心跳检测失败，尝试重新连接")
+			LBLogger.WithField("BotName", bCtx.Info().Name).Infoln(T("bot.heart-fail"))
 			// 关闭原来的连接
 			closeConn(bCtx)
 			connCQServer(bCtx, ReconnTimes)
@@ -244,39 +362,32 @@ const (
 	dataTypeResp    = 2
 )
 
-func parseData(data []byte, msgType string) (interface{}, int, error) {
+// parseData parses the data received, the message of an event is
+// decoded into Message whether the message type is array or string.
+func parseData(data []byte) (interface{}, int, error) {
 	var e Event
-	switch msgType {
-	case MsgTypeArray:
-		e.Message = make([]MsgSeg, 0)
-	case MsgTypeString:
-		e.Message = ""
-	}
 	err := json.Unmarshal(data, &e)
 	if err != nil || e.PostType == "" || e.Time == 0 {
 		var resp ApiResp
 		err = json.Unmarshal(data, &resp)
 		if err != nil {
-			return nil, dataFormatError, errors.New("读入数据解析失败，数据格式异常。")
+			return nil, dataFormatError, errors.New(T("err.data-format"))
 		}
 		return &resp, dataTypeResp, nil
 	}
 	return &e, dataTypeEvent, nil
 }
 
-func receiveData(bCtx *BotContext) {
+func receiveData(bCtx *BotContext, conn *ws.Conn, closeChan chan byte) {
 	for {
 		var (
 			err  error
 			data []byte
 		)
-		if !bCtx.IsReady {
-			break
-		}
-		_, data, err = bCtx.Conn.ReadMessage()
+		_, data, err = conn.ReadMessage()
 		if err != nil {
-			LBLogger.WithField("BotName", bCtx.BotInfo.Name).Debugln("Bot消息读取异常")
-			closeConn(bCtx)
+			LBLogger.WithField("BotName", bCtx.Info().Name).Debugln(T("bot.read-fail"))
+			closeConnOf(bCtx, conn)
 			break
 		}
 		if len(data) == 0 {
@@ -286,24 +397,22 @@ func receiveData(bCtx *BotContext) {
 			result interface{}
 			dt     int
 		)
-		result, dt, err = parseData(data, bCtx.BotInfo.MessageType)
+		result, dt, err = parseData(data)
 		if err != nil {
-			LBLogger.WithField("BotName", bCtx.BotInfo.Name).WithField("Data", string(data)).Warningln(err)
+			LBLogger.WithField("BotName", bCtx.Info().Name).WithField("Data", string(data)).Warningln(err)
 			continue
 		}
 		switch dt {
 		case dataTypeEvent:
-			e, hookPass := (result.(*Event)), true
+			e := result.(*Event)
 			// LBLogger.Debugln("receive msg: ", *e)
-			for _, hook := range eventInChain {
-				err = hook(e, *bCtx.BotInfo)
-				if err != nil {
-					LBLogger.WithField("BotName", bCtx.BotInfo.Name).Infoln(err)
-					hookPass = false
-					break
-				}
+			err = runEventIn(e, bCtx)
+			if errors.Is(err, ErrEventBlocked) || errors.Is(err, ErrEventDuplicated) {
+				LBLogger.WithField("BotName", bCtx.Info().Name).Debugln(err)
+				break
 			}
-			if !hookPass {
+			if err != nil {
+				LBLogger.WithField("BotName", bCtx.Info().Name).Infoln(err)
 				break
 			}
 			eCtx := eventContext{
@@ -312,7 +421,7 @@ func receiveData(bCtx *BotContext) {
 			}
 			select {
 			case cqEventChan <- eCtx:
-			case <-bCtx.CloseChan:
+			case <-closeChan:
 				return
 			}
 		case dataTypeResp:
@@ -322,68 +431,112 @@ func receiveData(bCtx *BotContext) {
 			}
 			select {
 			case cqRespChan <- rCtx:
-			case <-bCtx.CloseChan:
+			case <-closeChan:
 				return
 			}
 		}
 	}
 }
 
-func sendData(bCtx *BotContext) {
+func sendData(bCtx *BotContext, conn *ws.Conn, closeChan chan byte) {
+	bCtx.CloseLock.Lock()
+	parts := bCtx.unsent
+	bCtx.unsent = nil
+	bCtx.CloseLock.Unlock()
+	if !writeParts(bCtx, conn, parts) {
+		return
+	}
 	for {
-		if !bCtx.IsReady {
-			break
+		var api ApiPost
+		select {
+		case api = <-bCtx.OutChan:
+		case <-closeChan:
+			return
 		}
-		var (
-			hookPass = true
-			err      error
-		)
-		api := <-bCtx.OutChan
-		for _, hook := range beforeApiOutChain {
-			err = hook(&api, *bCtx.BotInfo)
-			if err != nil {
-				LBLogger.WithField("BotName", bCtx.BotInfo.Name).Infoln(err)
-				hookPass = false
-				break
-			}
+		if !writeParts(bCtx, conn, passApi(bCtx, api)) {
+			return
 		}
-		if !hookPass {
-			continue
+	}
+}
+
+// passApi passes the api through the hooks, and splits the message passed
+// by the thresholds of the bot, so that the hooks, the filter included,
+// check the whole message. As the response of an api aborted never comes,
+// the callback waiting for it gets the error at once, such as ErrMsgFiltered.
+func passApi(bCtx *BotContext, api ApiPost) []ApiPost {
+	var parts []ApiPost
+	err := runApiOut(&api, bCtx, func() error {
+		parts = splitApi(api, bCtx.Info())
+		return nil
+	})
+	if parts == nil && err == nil {
+		err = ErrApiAborted
+	}
+	if err != nil {
+		LBLogger.WithField("BotName", bCtx.Info().Name).Infoln(err)
+	}
+	if parts == nil && api.Echo != "" {
+		if callback := takeEchoCallback(api.Echo); callback != nil {
+			go callback(&ApiResp{err: err, Echo: api.Echo, Status: RespStatusFailed}, *bCtx.Info())
 		}
-		err = bCtx.Conn.WriteJSON(api)
-		if err != nil {
-			LBLogger.WithField("BotName", bCtx.BotInfo.Name).Debugln("Bot消息发送异常")
-			closeConn(bCtx)
-			bCtx.OutChan <- api
-			break
+	}
+	return parts
+}
+
+// writeParts writes the parts of an api in order, the messages are kept by
+// the history if it is enabled. If writing fails, the connection is closed,
+// and the part failed and the rest are kept to be written first after
+// reconnecting.
+func writeParts(bCtx *BotContext, conn *ws.Conn, parts []ApiPost) bool {
+	for i := range parts {
+		if CurConf().History.Enable {
+			recordSent(&parts[i], *bCtx.Info())
+		}
+		if err := conn.WriteJSON(parts[i]); err != nil {
+			LBLogger.WithField("BotName", bCtx.Info().Name).Debugln(T("bot.write-fail"))
+			bCtx.CloseLock.Lock()
+			bCtx.unsent = append(bCtx.unsent, parts[i:]...)
+			bCtx.CloseLock.Unlock()
+			closeConnOf(bCtx, conn)
+			return false
 		}
 	}
+	return true
 }
 
 func closeConn(bCtx *BotContext) {
+	closeConnOf(bCtx, nil)
+}
+
+// closeConnOf closes the connection of the bot only when it is still conn,
+// a nil conn means closing whatever the bot is holding.
+func closeConnOf(bCtx *BotContext, conn *ws.Conn) {
 	bCtx.CloseLock.Lock()
-	defer bCtx.CloseLock.Unlock()
-	if !bCtx.IsReady {
+	if !bCtx.IsReady || (conn != nil && bCtx.Conn != conn) {
+		bCtx.CloseLock.Unlock()
 		return
 	}
-	LBLogger.WithField("BotName", bCtx.BotInfo.Name).Debugln("正在尝试关闭已有连接")
+	LBLogger.WithField("BotName", bCtx.Info().Name).Debugln(T("bot.closing"))
 	err := bCtx.Conn.Close()
 	if err != nil {
-		LBLogger.WithField("BotName", bCtx.BotInfo.Name).Debugln("关闭连接异常！尚存在数据未读取", err)
+		LBLogger.WithField("BotName", bCtx.Info().Name).Debugln(T("bot.close-fail", err))
 	}
 	bCtx.IsReady = false
 	bCtx.Conn = nil
+	close(bCtx.CloseChan)
 	for _, hook := range disConnectChain {
-		hook(*bCtx.BotInfo)
+		hook(*bCtx.Info())
 	}
+	bCtx.CloseLock.Unlock()
+	runBotStateHooks(*bCtx.Info(), BotOffline)
 }
 
 func processMateEvent(e *Event, bCtx *BotContext) {
 	switch e.MetaEventType {
 	case Lifecycle:
-		if bCtx.BotInfo.BotID != e.SelfID {
-			le := LBLogger.WithField("BotName", bCtx.BotInfo.Name).WithField("BotId", bCtx.BotInfo.BotID).WithField("CQ-Server-Id", e.SelfID)
-			le.Warnln("CQ Server 账号ID与所配置的ID无法匹配，即将禁用该Bot")
+		if bCtx.Info().BotID != e.SelfID {
+			le := LBLogger.WithField("BotName", bCtx.Info().Name).WithField("BotId", bCtx.Info().BotID).WithField("CQ-Server-Id", e.SelfID)
+			le.Warnln(T("bot.id-mismatch"))
 			bCtx.FlagChan <- idMismatch
 		}
 	case Heartbeat:
@@ -0,0 +1,227 @@
+package luxtbot
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	GroupForwardMsgAction   = "send_group_forward_msg"
+	PrivateForwardMsgAction = "send_private_forward_msg"
+)
+
+// sentence ends used to split a long text when there is no line break
+const sentenceEnds = "。！？；!?;."
+
+type ForwardMsg struct {
+	GroupID  int64   `json:"group_id,omitempty"`
+	UserID   int64   `json:"user_id,omitempty"`
+	Messages Message `json:"messages"`
+}
+
+// splitApi splits an over-long message by the thresholds of the bot:
+// more than forward-lines lines makes a merged forward message,
+// more than max-msg-len characters of text makes several messages.
+// The echo is kept by the last api only, so a caller waiting for the
+// response gets the message_id of the last part, and the errors of the
+// other parts are not reported to it. The message_ids of all of the
+// parts are kept by the history if it is enabled.
+func splitApi(api ApiPost, bInfo *BotInfo) []ApiPost {
+	if bInfo.MaxMsgLen <= 0 && bInfo.ForwardLines <= 0 {
+		return []ApiPost{api}
+	}
+	var (
+		data    interface{}
+		groupID int64
+		userID  int64
+	)
+	switch params := api.Params.(type) {
+	case *GroupMsg:
+		data, groupID = params.Message, params.GroupID
+	case *PrivateMsg:
+		// forward messages could not be sent by temp session
+		data, userID = params.Message, params.UserID
+		groupID = params.GroupID
+	default:
+		return []ApiPost{api}
+	}
+	msg, isString := toMessage(data)
+	if bInfo.ForwardLines > 0 && msgLines(msg) > bInfo.ForwardLines && (userID == 0 || groupID == 0) {
+		fwd := makeForwardApi(msg, bInfo, groupID, userID)
+		fwd.Echo = api.Echo
+		return []ApiPost{fwd}
+	}
+	if bInfo.MaxMsgLen <= 0 || msgTextLen(msg) <= bInfo.MaxMsgLen {
+		return []ApiPost{api}
+	}
+	parts := splitMessage(msg, bInfo.MaxMsgLen)
+	apis := make([]ApiPost, 0, len(parts))
+	for _, part := range parts {
+		var partData interface{} = part
+		if isString {
+			partData = part.String()
+		}
+		var params interface{}
+		switch p := api.Params.(type) {
+		case *GroupMsg:
+			cp := *p
+			cp.Message = partData
+			params = &cp
+		case *PrivateMsg:
+			cp := *p
+			cp.Message = partData
+			params = &cp
+		}
+		apis = append(apis, makeApi(api.Action, params))
+	}
+	apis[len(apis)-1].Echo = api.Echo
+	return apis
+}
+
+func makeForwardApi(msg Message, bInfo *BotInfo, groupID, userID int64) ApiPost {
+	var (
+		nodes Message
+		cur   Message
+		lines int
+	)
+	for _, part := range splitLines(msg) {
+		cur = append(cur, part...)
+		cur = append(cur, textSeg("\n"))
+		lines++
+		if lines == bInfo.ForwardLines {
+			nodes = append(nodes, customNodeSeg(bInfo.Name, bInfo.BotID, strings.TrimSuffix(cur.String(), "\n")))
+			cur, lines = nil, 0
+		}
+	}
+	if len(cur) != 0 {
+		nodes = append(nodes, customNodeSeg(bInfo.Name, bInfo.BotID, strings.TrimSuffix(cur.String(), "\n")))
+	}
+	if userID != 0 {
+		return makeApi(PrivateForwardMsgAction, &ForwardMsg{UserID: userID, Messages: nodes})
+	}
+	return makeApi(GroupForwardMsgAction, &ForwardMsg{GroupID: groupID, Messages: nodes})
+}
+
+// splitLines splits the message by line breaks, unlike Message.Split
+// the empty lines are kept as empty messages.
+func splitLines(msg Message) []Message {
+	var (
+		lines []Message
+		cur   Message
+	)
+	for _, seg := range msg {
+		if seg.Type != TextMsgSeg {
+			cur = append(cur, seg)
+			continue
+		}
+		for i, text := range strings.Split(seg.Data["text"], "\n") {
+			if i > 0 {
+				lines = append(lines, cur)
+				cur = nil
+			}
+			if text != "" {
+				cur = append(cur, textSeg(text))
+			}
+		}
+	}
+	return append(lines, cur)
+}
+
+// toMessage returns the message in the string form or the array form,
+// the other shapes, such as the maps of the segments, are decoded from
+// their JSON. It is nil if the data is not a message.
+func toMessage(data interface{}) (Message, bool) {
+	switch msg := data.(type) {
+	case string:
+		return ParseMsgSegs(msg), true
+	case Message:
+		return msg, false
+	case []MsgSeg:
+		return msg, false
+	case nil:
+		return nil, false
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, false
+	}
+	if msg, ok := decodeMsgData(raw); ok {
+		return toMessage(msg)
+	}
+	return nil, false
+}
+
+func msgTextLen(msg Message) int {
+	n := 0
+	for _, seg := range msg.Segs(TextMsgSeg) {
+		n += len([]rune(seg.Data["text"]))
+	}
+	return n
+}
+
+func msgLines(msg Message) int {
+	return strings.Count(msg.PlainText(), "\n") + 1
+}
+
+// splitMessage splits the message into parts with text no longer than max,
+// at line breaks first, then sentence ends, or else exactly at max.
+func splitMessage(msg Message, max int) []Message {
+	var (
+		parts  []Message
+		cur    Message
+		curLen int
+	)
+	flush := func() {
+		if len(cur) != 0 {
+			parts = append(parts, cur)
+		}
+		cur, curLen = nil, 0
+	}
+	for _, seg := range msg {
+		if seg.Type != TextMsgSeg {
+			cur = append(cur, seg)
+			continue
+		}
+		text := []rune(seg.Data["text"])
+		for curLen+len(text) > max {
+			cut := findCut(text, max-curLen)
+			if cut == 0 {
+				if curLen == 0 {
+					cut = max
+				} else {
+					flush()
+					continue
+				}
+			}
+			if part := strings.TrimRight(string(text[:cut]), "\n"); part != "" {
+				cur = append(cur, textSeg(part))
+			}
+			flush()
+			text = []rune(strings.TrimLeft(string(text[cut:]), "\n"))
+		}
+		if len(text) != 0 {
+			cur = append(cur, textSeg(string(text)))
+			curLen += len(text)
+		}
+	}
+	flush()
+	return parts
+}
+
+// findCut returns where to cut the text within limit, 0 if no proper place.
+func findCut(text []rune, limit int) int {
+	if limit > len(text) {
+		limit = len(text)
+	}
+	for i := limit - 1; i >= 0; i-- {
+		if text[i] == '\n' {
+			return i + 1
+		}
+	}
+	for i := limit - 1; i >= 0; i-- {
+		if strings.ContainsRune(sentenceEnds, text[i]) {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,126 @@
+package luxtbot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// partText returns the message of a part, the contents of the nodes
+// joined by | for a forward message.
+func partText(api ApiPost) string {
+	var data interface{}
+	switch params := api.Params.(type) {
+	case *GroupMsg:
+		data = params.Message
+	case *PrivateMsg:
+		data = params.Message
+	case *ForwardMsg:
+		var contents []string
+		for _, node := range params.Messages {
+			contents = append(contents, node.Data["content"])
+		}
+		return strings.Join(contents, "|")
+	}
+	if msg, ok := data.(Message); ok {
+		return msg.String()
+	}
+	return data.(string)
+}
+
+func TestSplitApi(t *testing.T) {
+	face := MsgSeg{Type: FaceMsgSeg, Data: map[string]string{"id": "1"}}
+	tests := []struct {
+		name        string
+		bInfo       BotInfo
+		api         ApiPost
+		wantActions []string
+		wantTexts   []string
+	}{
+		{
+			name:        "off",
+			api:         makeApi(GroupMsgAction, &GroupMsg{GroupID: 1, Message: strings.Repeat("a", 100)}),
+			wantActions: []string{GroupMsgAction},
+			wantTexts:   []string{strings.Repeat("a", 100)},
+		},
+		{
+			name:        "not over",
+			bInfo:       BotInfo{MaxMsgLen: 5},
+			api:         makeApi(GroupMsgAction, &GroupMsg{GroupID: 1, Message: "hello"}),
+			wantActions: []string{GroupMsgAction},
+			wantTexts:   []string{"hello"},
+		},
+		{
+			name:        "line breaks",
+			bInfo:       BotInfo{MaxMsgLen: 5},
+			api:         makeApi(GroupMsgAction, &GroupMsg{GroupID: 1, Message: "ab\ncdefg\nhi"}),
+			wantActions: []string{GroupMsgAction, GroupMsgAction, GroupMsgAction},
+			wantTexts:   []string{"ab", "cdefg", "hi"},
+		},
+		{
+			name:        "sentence ends",
+			bInfo:       BotInfo{MaxMsgLen: 6},
+			api:         makeApi(PrivateMsgAction, &PrivateMsg{UserID: 2, Message: "你好。再见吧朋友"}),
+			wantActions: []string{PrivateMsgAction, PrivateMsgAction},
+			wantTexts:   []string{"你好。", "再见吧朋友"},
+		},
+		{
+			name:        "other segments not counted",
+			bInfo:       BotInfo{MaxMsgLen: 5},
+			api:         makeApi(GroupMsgAction, &GroupMsg{GroupID: 1, Message: Message{textSeg("abcd"), face, textSeg("ef")}}),
+			wantActions: []string{GroupMsgAction, GroupMsgAction},
+			wantTexts:   []string{"abcd[CQ:face,id=1]", "ef"},
+		},
+		{
+			name:        "forward",
+			bInfo:       BotInfo{ForwardLines: 2, MaxMsgLen: 1},
+			api:         makeApi(GroupMsgAction, &GroupMsg{GroupID: 1, Message: "a\nb\nc"}),
+			wantActions: []string{GroupForwardMsgAction},
+			wantTexts:   []string{"a\nb|c"},
+		},
+		{
+			name:        "forward keeps empty lines",
+			bInfo:       BotInfo{ForwardLines: 2},
+			api:         makeApi(PrivateMsgAction, &PrivateMsg{UserID: 2, Message: "a\n\nb"}),
+			wantActions: []string{PrivateForwardMsgAction},
+			wantTexts:   []string{"a\n|b"},
+		},
+		{
+			// forward messages could not be sent by temp session
+			name:        "no forward for temp session",
+			bInfo:       BotInfo{ForwardLines: 1},
+			api:         makeApi(PrivateMsgAction, &PrivateMsg{UserID: 2, GroupID: 1, Message: "a\nb"}),
+			wantActions: []string{PrivateMsgAction},
+			wantTexts:   []string{"a\nb"},
+		},
+		{
+			name:        "not a message",
+			bInfo:       BotInfo{MaxMsgLen: 1},
+			api:         makeApi(DeleteMsgAction, map[string]interface{}{"message_id": 1}),
+			wantActions: []string{DeleteMsgAction},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.api.Echo = "echo"
+			parts := splitApi(tt.api, &tt.bInfo)
+			var actions, texts []string
+			for i, part := range parts {
+				actions = append(actions, part.Action)
+				if tt.wantTexts != nil {
+					texts = append(texts, partText(part))
+				}
+				// the echo is kept by the last part only
+				if wantEcho := i == len(parts)-1; (part.Echo == "echo") != wantEcho {
+					t.Errorf("part %d has echo %q", i, part.Echo)
+				}
+			}
+			if !reflect.DeepEqual(actions, tt.wantActions) {
+				t.Errorf("actions = %q, want %q", actions, tt.wantActions)
+			}
+			if !reflect.DeepEqual(texts, tt.wantTexts) {
+				t.Errorf("texts = %q, want %q", texts, tt.wantTexts)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package luxtbot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const DefaultDataDir = "data"
+
+var storageLock sync.Mutex
+
+func dataPath(name string) string {
+	return filepath.Join(Conf.DataDir, name+".json")
+}
+
+// LoadData reads data-dir/<name>.json into v,
+// v is left untouched if the file does not exist.
+func LoadData(name string, v interface{}) error {
+	storageLock.Lock()
+	defer storageLock.Unlock()
+	data, err := ioutil.ReadFile(dataPath(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// SaveData writes v into data-dir/<name>.json,
+// by a temp file and renaming so that the file is never half written.
+func SaveData(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	storageLock.Lock()
+	defer storageLock.Unlock()
+	path := dataPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
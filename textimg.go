@@ -0,0 +1,85 @@
+package luxtbot
+
+import (
+	"encoding/base64"
+	"sync"
+
+	"github.com/ABiao0306/luxtbot/render"
+)
+
+type RenderConf struct {
+	// No font is embedded, the builtin font only has ASCII glyphs, so CJK
+	// text needs the path of a CJK font such as NotoSansCJK, or it is sent as text.
+	Font     string  `yaml:"font"`
+	FontSize float64 `yaml:"font-size"`
+	Width    int     `yaml:"width"`
+}
+
+var (
+	textRenderer *render.Renderer
+	renderLock   sync.Mutex
+)
+
+// RenderText draws text with simple markup into a PNG image by the render config,
+// see package render for the markup.
+func RenderText(text string) ([]byte, error) {
+	renderLock.Lock()
+	if textRenderer == nil {
+		conf := CurConf().Render
+		r, err := render.New(render.Options{
+			FontPath: conf.Font,
+			FontSize: conf.FontSize,
+			Width:    conf.Width,
+		})
+		if err != nil {
+			renderLock.Unlock()
+			return nil, err
+		}
+		textRenderer = r
+	}
+	r := textRenderer
+	renderLock.Unlock()
+	return r.Render(text)
+}
+
+// resetRenderer drops the renderer, so that the next RenderText loads the new config.
+func resetRenderer() {
+	renderLock.Lock()
+	textRenderer = nil
+	renderLock.Unlock()
+}
+
+func base64ImgSeg(data []byte, opts ...SegOption) MsgSeg {
+	return fileSeg(ImageMsgSeg, "base64://"+base64.StdEncoding.EncodeToString(data), "", opts...)
+}
+
+// textImgSeg renders text into an image segment,
+// the text itself is used if rendering failed, or the font has no glyphs for it.
+func textImgSeg(text string) MsgSeg {
+	data, err := RenderText(text)
+	if err != nil {
+		LBLogger.Warnln(T("msg.text-img-fail", err))
+		return textSeg(text)
+	}
+	return base64ImgSeg(data)
+}
+
+// AddImgData adds an image by its content, sent in base64.
+func (am *ArrayMsg) AddImgData(data []byte, opts ...SegOption) *ArrayMsg {
+	return am.AddSeg(base64ImgSeg(data, opts...))
+}
+
+// AddTextImg renders text into an image, for long or tabular replies.
+func (am *ArrayMsg) AddTextImg(text string) *ArrayMsg {
+	return am.AddSeg(textImgSeg(text))
+}
+
+// AddImgData adds an image by its content, sent in base64.
+func (tm *TextMsg) AddImgData(data []byte, opts ...SegOption) *TextMsg {
+	return tm.AddSeg(base64ImgSeg(data, opts...))
+}
+
+// AddTextImg renders text into an image, for long or tabular replies.
+func (tm *TextMsg) AddTextImg(text string) *TextMsg {
+	return tm.AddSeg(textImgSeg(text))
+}
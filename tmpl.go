@@ -0,0 +1,258 @@
+package luxtbot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const DefaultLocale = "zh-CN"
+
+// segments made by the template functions are kept in a table of the
+// render, and put in the output as their index and a random nonce of the
+// render between the markers, so that the data printed could never make
+// segments. The markers left in the output are dropped.
+const (
+	segBegin = '\uE000'
+	segEnd   = '\uE001'
+)
+
+var (
+	tmplCache     = make(map[string]*template.Template)
+	tmplCacheLock sync.RWMutex
+)
+
+// TmplFuncs are the functions usable in templates besides the builtin ones
+// and the ones making segments, add to it before templates are rendered.
+var TmplFuncs = template.FuncMap{}
+
+// segTable keeps the segments made by a render.
+type segTable struct {
+	nonce string
+	segs  []MsgSeg
+}
+
+func newSegTable() *segTable {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &segTable{nonce: hex.EncodeToString(b)}
+}
+
+func (st *segTable) mark(seg MsgSeg) string {
+	st.segs = append(st.segs, seg)
+	return string(segBegin) + st.nonce + strconv.Itoa(len(st.segs)-1) + string(segEnd)
+}
+
+// lookup returns the segment of the marked text between the markers.
+func (st *segTable) lookup(marked string) (MsgSeg, bool) {
+	if !strings.HasPrefix(marked, st.nonce) {
+		return MsgSeg{}, false
+	}
+	i, err := strconv.Atoi(marked[len(st.nonce):])
+	if err != nil || i < 0 || i >= len(st.segs) {
+		return MsgSeg{}, false
+	}
+	return st.segs[i], true
+}
+
+// segFuncs are the template functions making segments into the table.
+func segFuncs(st *segTable) template.FuncMap {
+	return template.FuncMap{
+		"at": func(uid int64) string {
+			return st.mark(atSeg(uid))
+		},
+		"atAll": func() string {
+			return st.mark(atSeg(AtAll))
+		},
+		"face": func(faceID int) string {
+			return st.mark(faceSeg(faceID))
+		},
+		"image": func(file string) string {
+			return st.mark(fileSeg(ImageMsgSeg, file, ""))
+		},
+		"record": func(file string) string {
+			return st.mark(fileSeg(RecordMsgSeg, file, ""))
+		},
+		"reply": func(msgID int) string {
+			return st.mark(replySeg(msgID))
+		},
+		"textImg": func(text string) string {
+			return st.mark(textImgSeg(text))
+		},
+	}
+}
+
+// parseTmpl parses the template text, parsed templates are cached by text.
+func parseTmpl(text string) (*template.Template, error) {
+	tmplCacheLock.RLock()
+	t, ok := tmplCache[text]
+	tmplCacheLock.RUnlock()
+	if ok {
+		return t, nil
+	}
+	t, err := template.New("").Funcs(TmplFuncs).Funcs(segFuncs(nil)).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	tmplCacheLock.Lock()
+	tmplCache[text] = t
+	tmplCacheLock.Unlock()
+	return t, nil
+}
+
+// RenderTemplate executes a text/template with data into a message.
+// Text of the output is kept as it is, only the segments made by
+// the functions at, atAll, face, image, record, reply and textImg become
+// segments, e.g. {{at .UserID}} 你好，{{.Name}}{{face 178}}
+func RenderTemplate(text string, data interface{}) (*ArrayMsg, error) {
+	t, err := parseTmpl(text)
+	if err != nil {
+		return nil, err
+	}
+	// the cached template is shared, bind the functions to a copy
+	t, err = t.Clone()
+	if err != nil {
+		return nil, err
+	}
+	st := newSegTable()
+	var buf bytes.Buffer
+	if err := t.Funcs(segFuncs(st)).Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return unmarkSegs(buf.String(), st), nil
+}
+
+// unmarkSegs splits the output of a template into segments.
+func unmarkSegs(out string, st *segTable) *ArrayMsg {
+	msg := MakeArrayMsg(1)
+	var text strings.Builder
+	for out != "" {
+		begin := strings.IndexRune(out, segBegin)
+		if begin < 0 {
+			text.WriteString(out)
+			break
+		}
+		text.WriteString(out[:begin])
+		out = out[begin+len(string(segBegin)):]
+		end := strings.IndexRune(out, segEnd)
+		if end < 0 {
+			continue
+		}
+		seg, ok := st.lookup(out[:end])
+		if !ok {
+			// not made by the render, the marker is dropped and the rest is text
+			continue
+		}
+		if text.Len() > 0 {
+			msg.AddText(dropSegMarkers(text.String()))
+			text.Reset()
+		}
+		msg.AddSeg(seg)
+		out = out[end+len(string(segEnd)):]
+	}
+	if text.Len() > 0 {
+		msg.AddText(dropSegMarkers(text.String()))
+	}
+	return msg
+}
+
+func dropSegMarkers(s string) string {
+	return strings.NewReplacer(string(segBegin), "", string(segEnd), "").Replace(s)
+}
+
+// SetTemplate sets the default template of key in the locale.
+func (p *Plugin) SetTemplate(locale, key, text string) *Plugin {
+	if p.templates == nil {
+		p.templates = make(map[string]map[string]string)
+	}
+	if p.templates[locale] == nil {
+		p.templates[locale] = make(map[string]string)
+	}
+	p.templates[locale][key] = text
+	return p
+}
+
+// LoadTemplates loads the default templates of the plugin from dir,
+// every file is named by its locale, like zh-CN.yml or en-US.json,
+// and maps the keys to the templates.
+func (p *Plugin) LoadTemplates(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		format, err := confFormat(path)
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		data, err = toYAML(data, format)
+		if err != nil {
+			return errors.New(T("err.tmpl-file", path, err))
+		}
+		tmpls := make(map[string]string)
+		if err := yaml.UnmarshalStrict(data, &tmpls); err != nil {
+			return errors.New(T("err.tmpl-file", path, err))
+		}
+		locale := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		for key, text := range tmpls {
+			if _, err := parseTmpl(text); err != nil {
+				return errors.New(T("err.tmpl", path, key, err))
+			}
+			p.SetTemplate(locale, key, text)
+		}
+	}
+	return nil
+}
+
+// Template returns the template of key: the one in templates.<Name>
+// of the config first, then the one in the locale of the config,
+// then the one in DefaultLocale.
+func (p *Plugin) Template(key string) (string, bool) {
+	if text, ok := CurConf().Templates[p.Name][key]; ok {
+		return text, true
+	}
+	for _, locale := range []string{CurConf().Locale, DefaultLocale} {
+		if text, ok := p.templates[locale][key]; ok {
+			return text, true
+		}
+	}
+	return "", false
+}
+
+// Render renders the template of key with data into a message.
+func (p *Plugin) Render(key string, data interface{}) (*ArrayMsg, error) {
+	text, ok := p.Template(key)
+	if !ok {
+		return nil, errors.New(T("err.tmpl-not-found", p.Name, key))
+	}
+	return RenderTemplate(text, data)
+}
+
+func validateTemplates(tmpls map[string]map[string]string) error {
+	for plg, keys := range tmpls {
+		for key, text := range keys {
+			if _, err := parseTmpl(text); err != nil {
+				return fmt.Errorf("templates.%v.%v: %v", plg, key, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package luxtbot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRenderTemplateInjection(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Message
+	}{
+		{"plain", "bob", Message{atSeg(1), textSeg(" bob"), faceSeg(2)}},
+		{"cq code", "[CQ:at,qq=all]", Message{atSeg(1), textSeg(" [CQ:at,qq=all]"), faceSeg(2)}},
+		{"markers", "\uE000[CQ:at,qq=all]\uE001", Message{atSeg(1), textSeg(" [CQ:at,qq=all]"), faceSeg(2)}},
+		{"index", "\uE0000\uE001", Message{atSeg(1), textSeg(" 0"), faceSeg(2)}},
+		{"unclosed", "\uE000x", Message{atSeg(1), textSeg(" x"), faceSeg(2)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := RenderTemplate("{{at 1}} {{.}}{{face 2}}", tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := msg.GetMsg()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
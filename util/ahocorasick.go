@@ -0,0 +1,107 @@
+package util
+
+import "unicode"
+
+// Matcher finds all of the words in a text at once by Aho-Corasick.
+type Matcher struct {
+	nodes      []acNode
+	ignoreCase bool
+}
+
+type acNode struct {
+	next map[rune]int
+	fail int
+	// lengths in runes of the words ending here, including by the fail links
+	outs []int
+}
+
+// Match is a word found, Start and End are indexes of runes.
+type Match struct {
+	Start int
+	End   int
+}
+
+func NewMatcher(words []string, ignoreCase bool) *Matcher {
+	m := &Matcher{nodes: []acNode{{}}, ignoreCase: ignoreCase}
+	for _, word := range words {
+		m.add([]rune(word))
+	}
+	m.build()
+	return m
+}
+
+func (m *Matcher) fold(r rune) rune {
+	if m.ignoreCase {
+		return unicode.ToLower(r)
+	}
+	return r
+}
+
+func (m *Matcher) add(word []rune) {
+	if len(word) == 0 {
+		return
+	}
+	cur := 0
+	for _, r := range word {
+		r = m.fold(r)
+		next, ok := m.nodes[cur].next[r]
+		if !ok {
+			if m.nodes[cur].next == nil {
+				m.nodes[cur].next = make(map[rune]int)
+			}
+			m.nodes = append(m.nodes, acNode{})
+			next = len(m.nodes) - 1
+			m.nodes[cur].next[r] = next
+		}
+		cur = next
+	}
+	m.nodes[cur].outs = append(m.nodes[cur].outs, len(word))
+}
+
+// build sets the fail links by BFS.
+func (m *Matcher) build() {
+	queue := make([]int, 0, len(m.nodes))
+	for _, next := range m.nodes[0].next {
+		queue = append(queue, next)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for r, next := range m.nodes[cur].next {
+			fail := m.nodes[cur].fail
+			for fail != 0 && m.nodes[fail].next[r] == 0 {
+				fail = m.nodes[fail].fail
+			}
+			if f, ok := m.nodes[fail].next[r]; ok && f != next {
+				m.nodes[next].fail = f
+			}
+			failOuts := m.nodes[m.nodes[next].fail].outs
+			m.nodes[next].outs = append(m.nodes[next].outs, failOuts...)
+			queue = append(queue, next)
+		}
+	}
+}
+
+// FindAll returns all of the words found in the text, overlapping ones included.
+func (m *Matcher) FindAll(text []rune) []Match {
+	var (
+		matches []Match
+		cur     int
+	)
+	for i, r := range text {
+		r = m.fold(r)
+		for cur != 0 && m.nodes[cur].next[r] == 0 {
+			cur = m.nodes[cur].fail
+		}
+		cur = m.nodes[cur].next[r]
+		for _, n := range m.nodes[cur].outs {
+			matches = append(matches, Match{Start: i + 1 - n, End: i + 1})
+		}
+	}
+	return matches
+}
+
+// Empty reports whether the matcher has no words.
+func (m *Matcher) Empty() bool {
+	return len(m.nodes) == 1
+}
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	tests := []struct {
+		name       string
+		words      []string
+		ignoreCase bool
+		text       string
+		want       []Match
+	}{
+		{"no words", nil, false, "abc", nil},
+		{"empty text", []string{"a"}, false, "", nil},
+		{"not found", []string{"abd"}, false, "abcabc", nil},
+		{"every occurrence", []string{"ab"}, false, "abxab", []Match{{0, 2}, {3, 5}}},
+		{"overlapping", []string{"aba"}, false, "ababa", []Match{{0, 3}, {2, 5}}},
+		{"nested", []string{"he", "she", "hers"}, false, "ushers", []Match{{1, 4}, {2, 4}, {2, 6}}},
+		{"by fail links", []string{"abcd", "bc"}, false, "abce", []Match{{1, 3}}},
+		{"case", []string{"Bad"}, false, "bad BAD Bad", []Match{{8, 11}}},
+		{"ignore case", []string{"Bad"}, true, "bad BAD Bad", []Match{{0, 3}, {4, 7}, {8, 11}}},
+		// indexes are of runes, not bytes
+		{"cjk", []string{"坏词", "词"}, false, "这是坏词", []Match{{2, 4}, {3, 4}}},
+		{"empty word", []string{"", "a"}, false, "a", []Match{{0, 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewMatcher(tt.words, tt.ignoreCase).FindAll([]rune(tt.text))
+			sort.Slice(got, func(i, j int) bool {
+				if got[i].Start != got[j].Start {
+					return got[i].Start < got[j].Start
+				}
+				return got[i].End < got[j].End
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindAll(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatcherEmpty(t *testing.T) {
+	if !NewMatcher(nil, false).Empty() || !NewMatcher([]string{""}, false).Empty() {
+		t.Error("a matcher without words is not empty")
+	}
+	if NewMatcher([]string{"a"}, false).Empty() {
+		t.Error("a matcher with words is empty")
+	}
+}
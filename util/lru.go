@@ -0,0 +1,109 @@
+package util
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is a cache bounded by size, entries older than ttl are expired.
+// A zero ttl means never expire. It is safe for concurrent use.
+type LRU struct {
+	size  int
+	ttl   time.Duration
+	lock  sync.Mutex
+	ll    *list.List
+	items map[interface{}]*list.Element
+}
+
+type lruEntry struct {
+	key    interface{}
+	value  interface{}
+	expire time.Time
+}
+
+func NewLRU(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[interface{}]*list.Element),
+	}
+}
+
+// Add adds or updates the entry, and evicts the least recently used
+// ones over the size. It reports whether the key was in the cache.
+// An entry updated keeps its expiry, so that a key added again and again
+// still expires.
+func (c *LRU) Add(key, value interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var expire time.Time
+	if c.ttl > 0 {
+		expire = time.Now().Add(c.ttl)
+	}
+	if el, ok := c.items[key]; ok {
+		ent := el.Value.(*lruEntry)
+		existed := !ent.expired()
+		ent.value = value
+		if !existed {
+			ent.expire = expire
+		}
+		c.ll.MoveToFront(el)
+		return existed
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, expire: expire})
+	for c.size > 0 && c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+	return false
+}
+
+// Get returns the value of the key if it is not expired.
+func (c *LRU) Get(key interface{}) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	ent := el.Value.(*lruEntry)
+	if ent.expired() {
+		c.removeElement(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return ent.value, true
+}
+
+func (c *LRU) Remove(key interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Len returns the count of the entries, the expired ones not removed yet included.
+func (c *LRU) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ll.Len()
+}
+
+// Purge removes all of the entries.
+func (c *LRU) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ll.Init()
+	c.items = make(map[interface{}]*list.Element)
+}
+
+func (c *LRU) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruEntry).key)
+}
+
+func (ent *lruEntry) expired() bool {
+	return !ent.expire.IsZero() && time.Now().After(ent.expire)
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	c := NewLRU(2, 0)
+	steps := []struct {
+		key     string
+		existed bool
+	}{
+		{"a", false},
+		{"a", true},
+		{"b", false},
+		// a is used more recently than b, so b is evicted by c
+		{"a", true},
+		{"c", false},
+		{"b", false},
+		{"c", true},
+	}
+	for i, step := range steps {
+		if got := c.Add(step.key, i); got != step.existed {
+			t.Errorf("step %d: Add(%q) = %v, want %v", i, step.key, got, step.existed)
+		}
+	}
+	if n := c.Len(); n != 2 {
+		t.Errorf("Len() = %d, want 2", n)
+	}
+	if v, ok := c.Get("c"); !ok || v != 6 {
+		t.Errorf("Get(c) = %v, %v, want 6, true", v, ok)
+	}
+	c.Remove("c")
+	if _, ok := c.Get("c"); ok {
+		t.Error("Get(c) found after Remove")
+	}
+	c.Purge()
+	if n := c.Len(); n != 0 {
+		t.Errorf("Len() = %d after Purge, want 0", n)
+	}
+}
+
+func TestLRUExpire(t *testing.T) {
+	ttl := 50 * time.Millisecond
+	c := NewLRU(0, ttl)
+	c.Add("a", 1)
+	time.Sleep(ttl / 2)
+	// adding again does not extend the expiry
+	if !c.Add("a", 2) {
+		t.Fatal("Add(a) again reported absent")
+	}
+	time.Sleep(ttl/2 + 10*time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("Get(a) found after the ttl")
+	}
+	if c.Add("a", 3) {
+		t.Error("Add(a) after the ttl reported present")
+	}
+	if v, ok := c.Get("a"); !ok || v != 3 {
+		t.Errorf("Get(a) = %v, %v, want 3, true", v, ok)
+	}
+}